@@ -0,0 +1,104 @@
+package sqlstore
+
+import (
+	"errors"
+	"github.com/RangelReale/osin"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidTarget is returned when a token is requested or used for a resource
+// outside of its audience. The message is the error code of RFC 8707.
+var ErrInvalidTarget = errors.New("invalid_target")
+
+// Audience returns the audience (the resource indicators of RFC 8707) stored in
+// the extended user data of authorize or access data. Data without an audience
+// is not restricted.
+func Audience(userData interface{}) []string {
+	_, ext := splitUserData(userData)
+	return ext.Audience
+}
+
+// ResourceIndicators returns the resource parameters of an authorization or token
+// request. Resources must be absolute URIs without a fragment.
+func ResourceIndicators(r *http.Request) ([]string, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	resources := r.Form["resource"]
+	for _, resource := range resources {
+		u, err := url.Parse(resource)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return nil, ErrInvalidTarget
+		}
+	}
+	return resources, nil
+}
+
+// CheckAudience returns ErrInvalidTarget if the access data is restricted to an
+// audience that does not include resource
+func CheckAudience(accessData *osin.AccessData, resource string) error {
+	audience := Audience(accessData.UserData)
+	if len(audience) == 0 || containsAll(audience, []string{resource}) {
+		return nil
+	}
+	return ErrInvalidTarget
+}
+
+// CheckInfoAudience enforces the audience of an info request returned by
+// osin.Server.HandleInfoRequest. It sets an error on resp and returns false if
+// the token can not be used for resource, and adds the audience to the output
+// otherwise.
+func CheckInfoAudience(resp *osin.Response, ir *osin.InfoRequest, resource string) bool {
+	if err := CheckAudience(ir.AccessData, resource); err != nil {
+		resp.SetError(osin.E_ACCESS_DENIED, "")
+		resp.InternalError = err
+		return false
+	}
+	if audience := Audience(ir.AccessData.UserData); len(audience) > 0 {
+		resp.Output["aud"] = audience
+	}
+	return true
+}
+
+// accessAudience returns the audience to save for access data. Access data without
+// an audience inherits the audience of the previous access data it was refreshed
+// from, or of the authorize data it was issued for. An explicit audience must be
+// within the inherited one.
+func accessAudience(accessData *osin.AccessData, audience []string) ([]string, error) {
+	var granted []string
+	if accessData.AccessData != nil {
+		granted = Audience(accessData.AccessData.UserData)
+	} else if accessData.AuthorizeData != nil {
+		granted = Audience(accessData.AuthorizeData.UserData)
+	}
+
+	if len(audience) == 0 {
+		return granted, nil
+	}
+	if len(granted) > 0 && !containsAll(granted, audience) {
+		return nil, ErrInvalidTarget
+	}
+	return audience, nil
+}
+
+// audienceArg returns the query argument for an audience column
+func audienceArg(audience []string) interface{} {
+	return nullString(strings.Join(audience, " "))
+}
+
+// containsAll reports whether all of values are in set
+func containsAll(set, values []string) bool {
+	contained := map[string]bool{}
+	for _, v := range set {
+		contained[v] = true
+	}
+	for _, v := range values {
+		if !contained[v] {
+			return false
+		}
+	}
+	return true
+}
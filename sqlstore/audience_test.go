@@ -0,0 +1,138 @@
+package sqlstore
+
+import (
+	"github.com/RangelReale/osin"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudience(t *testing.T) {
+	client := &osin.DefaultClient{Id: "audienceclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := testingContext.Store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveClient(client.Id)
+
+	audience := []string{"https://api.example.com", "https://files.example.com"}
+	authData := &osin.AuthorizeData{Code: "audiencecode", ExpiresIn: 100, Scope: "read",
+		RedirectUri: "redirect", CreatedAt: time.Now(), Client: client,
+		UserData: &ExtendedUserData{UserData: userData[0], Audience: audience}}
+	if err := testingContext.Store.SaveAuthorize(authData); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveAuthorize(authData.Code)
+
+	retAuthData, err := testingContext.Store.LoadAuthorize(authData.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(Audience(retAuthData.UserData), audience) {
+		t.Errorf("\"%v\": expected %v", Audience(retAuthData.UserData), audience)
+	}
+
+	// Access data without an audience inherits the audience of the authorize data
+	accessData := &osin.AccessData{AccessToken: "audienceaccess", RefreshToken: "audiencerefresh",
+		ExpiresIn: 100, CreatedAt: time.Now(), Client: client, AuthorizeData: retAuthData, UserData: userData[0]}
+	if err := testingContext.Store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveAccess(accessData.AccessToken)
+
+	retAccessData, err := testingContext.Store.LoadAccess(accessData.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(Audience(retAccessData.UserData), audience) {
+		t.Errorf("\"%v\": expected %v", Audience(retAccessData.UserData), audience)
+	}
+	ext := retAccessData.UserData.(*ExtendedUserData)
+	if !reflect.DeepEqual(ext.UserData, userData[0]) {
+		t.Errorf("\"%v\": expected %v", ext.UserData, userData[0])
+	}
+
+	// A refreshed token can narrow the audience of the previous token but not widen it
+	refreshed := &osin.AccessData{AccessToken: "audienceaccess2", ExpiresIn: 100, CreatedAt: time.Now(),
+		Client: client, AccessData: retAccessData,
+		UserData: &ExtendedUserData{Audience: []string{"https://evil.example.com"}}}
+	if err := testingContext.Store.SaveAccess(refreshed); err != ErrInvalidTarget {
+		t.Errorf("\"%v\": expected %v", err, ErrInvalidTarget)
+	}
+	refreshed.UserData = &ExtendedUserData{Audience: audience[:1]}
+	if err := testingContext.Store.SaveAccess(refreshed); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveAccess(refreshed.AccessToken)
+
+	// The narrowed audience is carried to the next refresh
+	retRefreshed, err := testingContext.Store.LoadAccess(refreshed.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &osin.AccessData{AccessToken: "audienceaccess3", ExpiresIn: 100, CreatedAt: time.Now(),
+		Client: client, AccessData: retRefreshed}
+	if err := testingContext.Store.SaveAccess(next); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveAccess(next.AccessToken)
+
+	retNext, err := testingContext.Store.LoadAccess(next.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(Audience(retNext.UserData), audience[:1]) {
+		t.Errorf("\"%v\": expected %v", Audience(retNext.UserData), audience[:1])
+	}
+	if err := CheckAudience(retNext, audience[0]); err != nil {
+		t.Error(err)
+	}
+	if err := CheckAudience(retNext, audience[1]); err != ErrInvalidTarget {
+		t.Errorf("\"%v\": expected %v", err, ErrInvalidTarget)
+	}
+
+	// The info endpoint rejects tokens for other resources
+	resp := osin.NewServer(osin.NewServerConfig(), testingContext.Store).NewResponse()
+	defer resp.Close()
+	ir := &osin.InfoRequest{Code: next.AccessToken, AccessData: retNext}
+	if CheckInfoAudience(resp, ir, audience[1]) || resp.ErrorId != osin.E_ACCESS_DENIED {
+		t.Errorf("Info request for %s should be denied", audience[1])
+	}
+}
+
+func TestCheckAudienceUnrestricted(t *testing.T) {
+	accessData := &osin.AccessData{AccessToken: "unrestricted", UserData: userData[0]}
+	if err := CheckAudience(accessData, "https://api.example.com"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResourceIndicators(t *testing.T) {
+	tests := []struct {
+		resources []string
+		err       error
+	}{
+		{[]string{"https://api.example.com", "https://files.example.com/v1"}, nil},
+		{[]string{"api.example.com"}, ErrInvalidTarget},
+		{[]string{"https://api.example.com#fragment"}, ErrInvalidTarget},
+	}
+
+	for _, test := range tests {
+		form := url.Values{"resource": test.resources}
+		r, err := http.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resources, err := ResourceIndicators(r)
+		if err != test.err {
+			t.Errorf("%v: \"%v\": expected %v", test.resources, err, test.err)
+		}
+		if err == nil && !reflect.DeepEqual(resources, test.resources) {
+			t.Errorf("\"%v\": expected %v", resources, test.resources)
+		}
+	}
+}
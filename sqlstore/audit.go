@@ -0,0 +1,106 @@
+package sqlstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// AuditEventType is the kind of an audit event
+type AuditEventType string
+
+// Audit event types
+const (
+	AuditAuthorizeCodeIssued  AuditEventType = "authorize_code_issued"
+	AuditAccessTokenIssued    AuditEventType = "access_token_issued"
+	AuditAccessTokenRefreshed AuditEventType = "access_token_refreshed"
+	AuditAuthorizeCodeRemoved AuditEventType = "authorize_code_removed"
+	AuditAccessTokenRemoved   AuditEventType = "access_token_removed"
+	AuditRefreshTokenRemoved  AuditEventType = "refresh_token_removed"
+	AuditDeviceCodeRemoved    AuditEventType = "device_code_removed"
+	AuditClientRemoved        AuditEventType = "client_removed"
+)
+
+// AuditEvent records that a code or token was issued or removed, or that a client
+// was removed. Relays deliver the same events from the outbox.
+type AuditEvent struct {
+	ID       string
+	Type     AuditEventType
+	ClientID string
+	Subject  string
+	// TokenFingerprint is the TokenFingerprint of the code or token, which is empty
+	// for removed clients
+	TokenFingerprint string
+	CreatedAt        time.Time
+}
+
+// AuditFilter selects audit events. Empty fields select all events.
+type AuditFilter struct {
+	ClientID string
+	Subject  string
+	// Since and Until bound the time of the events. Since is inclusive and Until
+	// is exclusive.
+	Since time.Time
+	Until time.Time
+}
+
+// WithAuditEvents records an audit event in the audit_events table for every
+// SaveAuthorize, SaveAccess, RemoveAuthorize, RemoveAccess, RemoveRefresh,
+// RemoveDeviceCode and RemoveClient. The event is written in the same transaction
+// as the change it records. Removing codes and tokens that do not exist records
+// no event. The events of RemoveClient are written in the token database.
+func WithAuditEvents() Option {
+	return func(store *SQLStorage) {
+		store.auditEvents = true
+	}
+}
+
+// TokenFingerprint returns the hex encoded SHA-256 hash of a code or token, which
+// identifies it in audit events without revealing it
+func TokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// insertAuditEvent writes an audit event in the transaction of a write
+func (store *SQLStorage) insertAuditEvent(ctx context.Context, tx Querier, event *AuditEvent) error {
+	_, err := store.exec(ctx, tx, store.rebind(`
+		INSERT INTO audit_events(tenant_id, id, event_type, client_id, subject, token_fingerprint, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+		`), store.tenantID, event.ID, string(event.Type), event.ClientID, nullString(event.Subject),
+		nullString(event.TokenFingerprint), event.CreatedAt)
+	return err
+}
+
+// AuditEvents returns the audit events of the tenant selected by filter, oldest first
+func (store *SQLStorage) AuditEvents(ctx context.Context, filter AuditFilter) (_ []*AuditEvent, err error) {
+	ctx, op := store.begin(ctx, "AuditEvents")
+	defer op.end(&err)
+
+	query := `SELECT id, event_type, client_id, subject, token_fingerprint, created_at FROM audit_events
+		WHERE tenant_id = ?`
+	args := []interface{}{store.tenantID}
+	if filter.ClientID != "" {
+		query += " AND client_id = ?"
+		args = append(args, filter.ClientID)
+	}
+	if filter.Subject != "" {
+		query += " AND subject = ?"
+		args = append(args, filter.Subject)
+	}
+	if !filter.Since.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		query += " AND created_at < ?"
+		args = append(args, filter.Until.UTC())
+	}
+
+	rows, err := store.query(ctx, store.authDB, store.rebind(query+" ORDER BY created_at, id"), args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
@@ -0,0 +1,214 @@
+package sqlstore
+
+import (
+	"context"
+	"github.com/RangelReale/osin"
+	"testing"
+	"time"
+)
+
+func TestAuditEvents(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db, WithAuditEvents())
+	start := time.Now()
+
+	client := &osin.DefaultClient{Id: "auditclient", Secret: "secret", RedirectUri: "redirect"}
+	other := &osin.DefaultClient{Id: "otherclient", Secret: "secret", RedirectUri: "redirect"}
+	for _, c := range []*osin.DefaultClient{client, other} {
+		if err := store.SetClient(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	authorizeData := &osin.AuthorizeData{Code: "auditcode", ExpiresIn: 100, CreatedAt: time.Now(), Client: client,
+		UserData: &ExtendedUserData{Subject: "alice"}}
+	if err := store.SaveAuthorize(authorizeData); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "auditaccess", RefreshToken: "auditrefresh", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client, AuthorizeData: authorizeData,
+		UserData: &ExtendedUserData{Subject: "alice"}}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	// A failed write records no event
+	if err := store.SaveAccess(accessData); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if err := store.RemoveAuthorize(authorizeData.Code); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed := &osin.AccessData{AccessToken: "auditaccess2", ExpiresIn: 100, CreatedAt: time.Now(), Client: client,
+		AccessData: accessData, UserData: &ExtendedUserData{Subject: "alice"}}
+	if err := store.SaveAccess(refreshed); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveRefresh(accessData.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	// Removing a token that does not exist records no event
+	if err := store.RemoveAccess("missing"); err != nil {
+		t.Fatal(err)
+	}
+
+	otherAccess := &osin.AccessData{AccessToken: "otheraccess", ExpiresIn: 100, CreatedAt: time.Now(), Client: other,
+		UserData: &ExtendedUserData{Subject: "bob"}}
+	if err := store.SaveAccess(otherAccess); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveClient(other.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := store.AuditEvents(ctx, AuditFilter{ClientID: client.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		typ   AuditEventType
+		token string
+	}{
+		{AuditAuthorizeCodeIssued, authorizeData.Code},
+		{AuditAccessTokenIssued, accessData.AccessToken},
+		{AuditAuthorizeCodeRemoved, authorizeData.Code},
+		{AuditAccessTokenRefreshed, refreshed.AccessToken},
+		{AuditRefreshTokenRemoved, accessData.RefreshToken},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("%d events, expected %d", len(events), len(expected))
+	}
+	for i, event := range events {
+		if event.Type != expected[i].typ || event.TokenFingerprint != TokenFingerprint(expected[i].token) ||
+			event.ClientID != client.Id || event.Subject != "alice" || event.CreatedAt.Before(start.Add(-time.Second)) {
+			t.Errorf("%d: unexpected event %+v, expected %v", i, event, expected[i].typ)
+		}
+	}
+
+	events, err = store.AuditEvents(ctx, AuditFilter{Subject: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != AuditAccessTokenIssued || events[0].ClientID != other.Id {
+		t.Errorf("Unexpected events %+v", events)
+	}
+	events, err = store.AuditEvents(ctx, AuditFilter{ClientID: other.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Type != AuditClientRemoved || events[1].TokenFingerprint != "" {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	// Time range
+	events, err = store.AuditEvents(ctx, AuditFilter{Until: start.Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Unexpected events %+v", events)
+	}
+	events, err = store.AuditEvents(ctx, AuditFilter{Since: start.Add(-time.Minute), Until: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 7 {
+		t.Errorf("%d events, expected 7", len(events))
+	}
+
+	// Events are only written if enabled
+	plain := NewSQLStorage(db)
+	if err := plain.RemoveAccess(refreshed.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := store.AuditEvents(ctx, AuditFilter{}); err != nil || len(events) != 7 {
+		t.Errorf("%d events, %v: expected 7", len(events), err)
+	}
+}
+
+func TestBulkRevocationEvents(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db, WithAuditEvents(), WithOutbox())
+	client := &osin.DefaultClient{Id: "bulkclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	for _, accessData := range []*osin.AccessData{
+		{AccessToken: "consentaccess", UserData: &ExtendedUserData{Subject: "alice"}},
+		{AccessToken: "subjectaccess", UserData: &ExtendedUserData{Subject: "bob"}},
+		{AccessToken: "derivedaccess", UserData: &ExtendedUserData{Subject: "bob",
+			TokenExchange: &TokenExchange{SubjectToken: "subjectaccess"}}},
+	} {
+		accessData.ExpiresIn = 100
+		accessData.CreatedAt = time.Now()
+		accessData.Client = client
+		if err := store.SaveAccess(accessData); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.RevokeConsent(ctx, "alice", client.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeSubjectToken(ctx, "subjectaccess", true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{"consentaccess", "subjectaccess", "derivedaccess"} {
+		fingerprint := TokenFingerprint(token)
+		var audited, outboxed int
+		row := db.QueryRow("SELECT COUNT(*) FROM audit_events WHERE event_type = ? AND token_fingerprint = ?",
+			AuditAccessTokenRemoved, fingerprint)
+		if err := row.Scan(&audited); err != nil {
+			t.Fatal(err)
+		}
+		row = db.QueryRow("SELECT COUNT(*) FROM outbox_events WHERE event_type = ? AND token_fingerprint = ?",
+			AuditAccessTokenRemoved, fingerprint)
+		if err := row.Scan(&outboxed); err != nil {
+			t.Fatal(err)
+		}
+		if audited != 1 || outboxed != 1 {
+			t.Errorf("%s: %d audit events and %d outbox events, expected 1", token, audited, outboxed)
+		}
+	}
+}
+
+func TestAuditEventsTimeZones(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewSQLStorage(db, WithAuditEvents(), WithClock(func() time.Time { return now }))
+	client := &osin.DefaultClient{Id: "zoneclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "zoneaccess", ExpiresIn: 100, CreatedAt: now, Client: client}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bounds use different offsets than the stored UTC times, which do not
+	// compare as text in SQLite
+	east := time.FixedZone("UTC+14", 14*3600)
+	west := time.FixedZone("UTC-10", -10*3600)
+	for _, filter := range []AuditFilter{
+		{Since: now.Add(-time.Minute).In(east), Until: now.Add(time.Minute).In(west)},
+		{Since: now.Add(-time.Minute).In(west), Until: now.Add(time.Minute).In(east)},
+	} {
+		if events, err := store.AuditEvents(ctx, filter); err != nil || len(events) != 1 {
+			t.Errorf("%+v: %d events, %v: expected 1", filter, len(events), err)
+		}
+	}
+	filter := AuditFilter{Since: now.Add(time.Minute).In(west)}
+	if events, err := store.AuditEvents(ctx, filter); err != nil || len(events) != 0 {
+		t.Errorf("%+v: %d events, %v: expected 0", filter, len(events), err)
+	}
+}
@@ -0,0 +1,183 @@
+package sqlstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidAuthorizationDetails is returned when authorization details have an
+// unknown type or fail validation. The message is the error code of RFC 9396.
+var ErrInvalidAuthorizationDetails = errors.New("invalid_authorization_details")
+
+// AuthorizationDetail is an entry of the authorization_details of a request
+// (RFC 9396). Implementations are marshaled as json and must include the type
+// field in their json.
+type AuthorizationDetail interface {
+	DetailType() string
+}
+
+// RawAuthorizationDetail holds a loaded authorization detail whose type is not
+// registered with WithAuthorizationDetailType. A detail loaded by the storage is
+// saved again without validation as long as it is unchanged, for example when osin
+// saves the details of a refreshed token. Other raw details are validated like any
+// detail of their type.
+type RawAuthorizationDetail struct {
+	Type string
+	JSON json.RawMessage
+
+	// loaded is the json the storage decoded the detail from
+	loaded json.RawMessage
+}
+
+// DetailType returns the type of the authorization detail
+func (detail *RawAuthorizationDetail) DetailType() string {
+	return detail.Type
+}
+
+// MarshalJSON returns the json the detail was loaded from
+func (detail *RawAuthorizationDetail) MarshalJSON() ([]byte, error) {
+	return detail.JSON, nil
+}
+
+// unchanged reports whether the detail was loaded by the storage and has not been
+// modified since
+func (detail *RawAuthorizationDetail) unchanged() bool {
+	return detail.loaded != nil && bytes.Equal(detail.JSON, detail.loaded)
+}
+
+// authorizationDetailType is a registered authorization detail type
+type authorizationDetailType struct {
+	new      func() AuthorizationDetail
+	validate func(AuthorizationDetail) error
+}
+
+// WithAuthorizationDetailType registers an authorization detail type. newDetail returns
+// a pointer to the Go value the details of the type are unmarshaled into, and validate,
+// which may be nil, is called for every detail of the type that is parsed or saved.
+// Details of unregistered types are rejected when they are saved.
+func WithAuthorizationDetailType(typ string, newDetail func() AuthorizationDetail, validate func(AuthorizationDetail) error) Option {
+	return func(store *SQLStorage) {
+		if store.detailTypes == nil {
+			store.detailTypes = map[string]authorizationDetailType{}
+		}
+		store.detailTypes[typ] = authorizationDetailType{newDetail, validate}
+	}
+}
+
+// ParseAuthorizationDetails parses and validates the authorization_details parameter of
+// an authorization or token request
+func (store *SQLStorage) ParseAuthorizationDetails(data string) ([]AuthorizationDetail, error) {
+	details, err := store.getAuthorizationDetails(data, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.validateAuthorizationDetails(details); err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// validateAuthorizationDetails runs the validation hooks of the registered types.
+// Unchanged raw details loaded by the storage are not validated again.
+func (store *SQLStorage) validateAuthorizationDetails(details []AuthorizationDetail) error {
+	for _, detail := range details {
+		if raw, ok := detail.(*RawAuthorizationDetail); ok && raw.unchanged() {
+			continue
+		}
+		typ, ok := store.detailTypes[detail.DetailType()]
+		if !ok {
+			return fmt.Errorf("%w: unknown type %q", ErrInvalidAuthorizationDetails, detail.DetailType())
+		}
+		if typ.validate == nil {
+			continue
+		}
+		// The validation hooks expect the Go values of their types
+		if raw, ok := detail.(*RawAuthorizationDetail); ok {
+			detail = typ.new()
+			if err := json.Unmarshal(raw.JSON, detail); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidAuthorizationDetails, err)
+			}
+		}
+		if err := typ.validate(detail); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidAuthorizationDetails, err)
+		}
+	}
+	return nil
+}
+
+// authorizationDetailsArg validates authorization details and returns the query
+// argument for the authorization_details column
+func (store *SQLStorage) authorizationDetailsArg(details []AuthorizationDetail) (interface{}, error) {
+	if len(details) == 0 {
+		return nil, nil
+	}
+	if err := store.validateAuthorizationDetails(details); err != nil {
+		return nil, err
+	}
+
+	elems := make([]json.RawMessage, len(details))
+	for i, detail := range details {
+		data, err := json.Marshal(detail)
+		if err != nil {
+			return nil, err
+		}
+
+		// The type field has to survive the round trip
+		var typed struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &typed); err != nil || typed.Type != detail.DetailType() {
+			return nil, fmt.Errorf("%w: %T does not marshal its type", ErrInvalidAuthorizationDetails, detail)
+		}
+		elems[i] = data
+	}
+
+	data, err := json.Marshal(elems)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// getAuthorizationDetails unmarshals authorization details into the Go values of
+// their registered types. Details of unknown types are rejected if strict is set
+// and returned as *RawAuthorizationDetail otherwise.
+func (store *SQLStorage) getAuthorizationDetails(data string, strict bool) ([]AuthorizationDetail, error) {
+	if data == "" {
+		return nil, nil
+	}
+
+	var elems []json.RawMessage
+	if err := json.Unmarshal([]byte(data), &elems); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAuthorizationDetails, err)
+	}
+
+	details := make([]AuthorizationDetail, len(elems))
+	for i, elem := range elems {
+		var typed struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(elem, &typed); err != nil || typed.Type == "" {
+			return nil, fmt.Errorf("%w: detail without type", ErrInvalidAuthorizationDetails)
+		}
+
+		typ, ok := store.detailTypes[typed.Type]
+		if !ok {
+			if strict {
+				return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidAuthorizationDetails, typed.Type)
+			}
+			details[i] = &RawAuthorizationDetail{Type: typed.Type, JSON: elem,
+				loaded: append(json.RawMessage(nil), elem...)}
+			continue
+		}
+
+		detail := typ.new()
+		if err := json.Unmarshal(elem, detail); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAuthorizationDetails, err)
+		}
+		details[i] = detail
+	}
+	return details, nil
+}
@@ -0,0 +1,230 @@
+package sqlstore
+
+import (
+	"errors"
+	"github.com/RangelReale/osin"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type paymentInitiation struct {
+	Type                string   `json:"type"`
+	Actions             []string `json:"actions"`
+	InstructedAmount    amount   `json:"instructedAmount"`
+	CreditorName        string   `json:"creditorName"`
+	CreditorAccountIBAN string   `json:"creditorAccountIban"`
+}
+
+type amount struct {
+	Currency string `json:"currency"`
+	Amount   string `json:"amount"`
+}
+
+func (p *paymentInitiation) DetailType() string {
+	return p.Type
+}
+
+func newDetailsStore() *SQLStorage {
+	return NewSQLStorage(testingContext.DB.DB(), WithAuthorizationDetailType("payment_initiation",
+		func() AuthorizationDetail { return &paymentInitiation{} },
+		func(detail AuthorizationDetail) error {
+			if detail.(*paymentInitiation).InstructedAmount.Currency != "EUR" {
+				return errors.New("only EUR payments are supported")
+			}
+			return nil
+		}))
+}
+
+func TestAuthorizationDetails(t *testing.T) {
+	store := newDetailsStore()
+
+	client := &osin.DefaultClient{Id: "detailsclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	defer store.RemoveClient(client.Id)
+
+	details, err := store.ParseAuthorizationDetails(`[{
+		"type": "payment_initiation",
+		"actions": ["initiate", "status"],
+		"instructedAmount": {"currency": "EUR", "amount": "123.50"},
+		"creditorName": "Merchant A",
+		"creditorAccountIban": "DE02100100109307118603"
+	}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authData := &osin.AuthorizeData{Code: "detailscode", ExpiresIn: 100, RedirectUri: "redirect",
+		CreatedAt: time.Now(), Client: client,
+		UserData: &ExtendedUserData{UserData: userData[0], AuthorizationDetails: details}}
+	if err := store.SaveAuthorize(authData); err != nil {
+		t.Fatal(err)
+	}
+	defer store.RemoveAuthorize(authData.Code)
+
+	accessData := &osin.AccessData{AccessToken: "detailsaccess", ExpiresIn: 100, CreatedAt: time.Now(),
+		Client: client, UserData: authData.UserData}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	defer store.RemoveAccess(accessData.AccessToken)
+
+	retAuthData, err := store.LoadAuthorize(authData.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retAccessData, err := store.LoadAccess(accessData.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []interface{}{retAuthData.UserData, retAccessData.UserData} {
+		ext, ok := data.(*ExtendedUserData)
+		if !ok {
+			t.Fatalf("User data has type %T", data)
+		}
+		if !reflect.DeepEqual(ext.AuthorizationDetails, details) {
+			t.Errorf("\"%v\": expected %v", ext.AuthorizationDetails, details)
+		}
+		if !reflect.DeepEqual(ext.UserData, userData[0]) {
+			t.Errorf("\"%v\": expected %v", ext.UserData, userData[0])
+		}
+	}
+
+	// A store without the registered type loads the raw json
+	retAccessData, err = testingContext.Store.LoadAccess(accessData.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := retAccessData.UserData.(*ExtendedUserData).AuthorizationDetails
+	if len(raw) != 1 || raw[0].DetailType() != "payment_initiation" {
+		t.Errorf("\"%v\": expected a payment_initiation detail", raw)
+	}
+	if _, ok := raw[0].(*RawAuthorizationDetail); !ok {
+		t.Errorf("Detail has type %T", raw[0])
+	}
+}
+
+func TestInvalidAuthorizationDetails(t *testing.T) {
+	store := newDetailsStore()
+
+	for _, data := range []string{
+		`{"type": "payment_initiation"}`,
+		`[{"actions": ["read"]}]`,
+		`[{"type": "account_information"}]`,
+		`[{"type": "payment_initiation", "instructedAmount": {"currency": "USD", "amount": "1"}}]`,
+	} {
+		if _, err := store.ParseAuthorizationDetails(data); !errors.Is(err, ErrInvalidAuthorizationDetails) {
+			t.Errorf("%s: \"%v\": expected %v", data, err, ErrInvalidAuthorizationDetails)
+		}
+	}
+
+	// Saving runs the validation hooks
+	client := &osin.DefaultClient{Id: "detailsclient2", Secret: "secret", RedirectUri: "redirect"}
+	accessData := &osin.AccessData{AccessToken: "invaliddetails", ExpiresIn: 100, CreatedAt: time.Now(),
+		Client: client, UserData: &ExtendedUserData{AuthorizationDetails: []AuthorizationDetail{
+			&paymentInitiation{Type: "payment_initiation", InstructedAmount: amount{"USD", "1"}},
+		}}}
+	if err := store.SaveAccess(accessData); !errors.Is(err, ErrInvalidAuthorizationDetails) {
+		t.Errorf("\"%v\": expected %v", err, ErrInvalidAuthorizationDetails)
+	}
+}
+
+func TestRawAuthorizationDetailsRefresh(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db, WithAuthorizationDetailType("payment_initiation",
+		func() AuthorizationDetail { return &paymentInitiation{} }, nil))
+	client := &osin.DefaultClient{Id: "rawclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	details, err := store.ParseAuthorizationDetails(
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"1"}}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "rawaccess", RefreshToken: "rawrefresh", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client, UserData: &ExtendedUserData{AuthorizationDetails: details}}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	var saved string
+	if err := db.QueryRow("SELECT authorization_details FROM access_data WHERE access_token = ?",
+		accessData.AccessToken).Scan(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	// A store without the registered type refreshes the token as osin does, saving
+	// the loaded details again
+	plain := NewSQLStorage(db)
+	loaded, err := plain.LoadRefresh(accessData.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed := &osin.AccessData{AccessToken: "rawaccess2", ExpiresIn: 100, CreatedAt: time.Now(),
+		Client: client, AccessData: loaded, UserData: loaded.UserData}
+	if err := plain.SaveAccess(refreshed); err != nil {
+		t.Fatal(err)
+	}
+	var resaved string
+	if err := db.QueryRow("SELECT authorization_details FROM access_data WHERE access_token = ?",
+		refreshed.AccessToken).Scan(&resaved); err != nil {
+		t.Fatal(err)
+	}
+	if resaved != saved {
+		t.Errorf("\"%s\": expected %s", resaved, saved)
+	}
+}
+
+func TestHandBuiltRawAuthorizationDetails(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	plain := NewSQLStorage(db)
+	store := NewSQLStorage(db, WithAuthorizationDetailType("payment_initiation",
+		func() AuthorizationDetail { return &paymentInitiation{} },
+		func(detail AuthorizationDetail) error {
+			if detail.(*paymentInitiation).InstructedAmount.Currency != "EUR" {
+				return errors.New("only EUR payments are supported")
+			}
+			return nil
+		}))
+	client := &osin.DefaultClient{Id: "handbuiltclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	save := func(store *SQLStorage, token string, detail AuthorizationDetail) error {
+		return store.SaveAccess(&osin.AccessData{AccessToken: token, ExpiresIn: 100, CreatedAt: time.Now(),
+			Client: client, UserData: &ExtendedUserData{AuthorizationDetails: []AuthorizationDetail{detail}}})
+	}
+	raw := func(json string) *RawAuthorizationDetail {
+		return &RawAuthorizationDetail{Type: "payment_initiation", JSON: []byte(json)}
+	}
+
+	// Raw details that were not loaded by the storage are validated
+	if err := save(plain, "handbuilt1", raw(`{"type":"payment_initiation"}`)); !errors.Is(err, ErrInvalidAuthorizationDetails) {
+		t.Errorf("\"%v\": expected %v", err, ErrInvalidAuthorizationDetails)
+	}
+	usd := raw(`{"type":"payment_initiation","instructedAmount":{"currency":"USD","amount":"1"}}`)
+	if err := save(store, "handbuilt2", usd); !errors.Is(err, ErrInvalidAuthorizationDetails) {
+		t.Errorf("\"%v\": expected %v", err, ErrInvalidAuthorizationDetails)
+	}
+	eur := raw(`{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"1"}}`)
+	if err := save(store, "handbuilt3", eur); err != nil {
+		t.Fatal(err)
+	}
+
+	// So are loaded raw details that were modified
+	loaded, err := plain.LoadAccess("handbuilt3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	detail := loaded.UserData.(*ExtendedUserData).AuthorizationDetails[0].(*RawAuthorizationDetail)
+	detail.JSON = usd.JSON
+	if err := save(plain, "handbuilt4", detail); !errors.Is(err, ErrInvalidAuthorizationDetails) {
+		t.Errorf("\"%v\": expected %v", err, ErrInvalidAuthorizationDetails)
+	}
+}
@@ -0,0 +1,104 @@
+package sqlstore
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache is the key-value cache used by CachedStorage. Implementations must be
+// safe for concurrent use.
+type Cache interface {
+	// Get returns the value of key if it is cached and has not expired
+	Get(key string) (interface{}, bool)
+	// Set caches value for key for ttl
+	Set(key string, value interface{}, ttl time.Duration)
+	// Delete removes key from the cache
+	Delete(key string)
+}
+
+// LRUCache is an in-process Cache that evicts the least recently used entries
+// once it holds its maximum number of entries
+type LRUCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+// lruEntry is an element of the LRU order
+type lruEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// NewLRUCache returns an LRUCache holding at most size entries
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+		now:     time.Now,
+	}
+}
+
+func (cache *LRUCache) Get(key string) (interface{}, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	elem, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !cache.now().Before(entry.expiresAt) {
+		cache.remove(elem)
+		return nil, false
+	}
+	cache.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (cache *LRUCache) Set(key string, value interface{}, ttl time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	expiresAt := cache.now().Add(ttl)
+	if elem, ok := cache.entries[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		cache.order.MoveToFront(elem)
+		return
+	}
+
+	cache.entries[key] = cache.order.PushFront(&lruEntry{key, value, expiresAt})
+	for cache.order.Len() > cache.size {
+		cache.remove(cache.order.Back())
+	}
+}
+
+func (cache *LRUCache) Delete(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if elem, ok := cache.entries[key]; ok {
+		cache.remove(elem)
+	}
+}
+
+// Len returns the number of cached entries, including expired entries that have
+// not been evicted yet
+func (cache *LRUCache) Len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.order.Len()
+}
+
+// remove removes an element while the lock is held
+func (cache *LRUCache) remove(elem *list.Element) {
+	cache.order.Remove(elem)
+	delete(cache.entries, elem.Value.(*lruEntry).key)
+}
@@ -0,0 +1,40 @@
+package sqlstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Now()
+	cache := NewLRUCache(2)
+	cache.now = func() time.Time { return now }
+
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, time.Minute)
+	if value, ok := cache.Get("a"); !ok || value != 1 {
+		t.Errorf("\"%v\": expected 1", value)
+	}
+
+	// b is the least recently used entry
+	cache.Set("c", 3, time.Minute)
+	if _, ok := cache.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("\"%v\": expected 2", cache.Len())
+	}
+
+	cache.Delete("a")
+	if _, ok := cache.Get("a"); ok {
+		t.Error("a should have been deleted")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cache.Get("c"); ok {
+		t.Error("c should have expired")
+	}
+	if cache.Len() != 0 {
+		t.Errorf("\"%v\": expected 0", cache.Len())
+	}
+}
@@ -0,0 +1,389 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/RangelReale/osin"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default time to live of the entries cached by CachedStorage
+const (
+	DefaultClientTTL   = 5 * time.Minute
+	DefaultAccessTTL   = time.Minute
+	DefaultNegativeTTL = 5 * time.Second
+)
+
+// Key prefixes of the entries cached by CachedStorage
+const (
+	clientKeyPrefix  = "client:"
+	accessKeyPrefix  = "access:"
+	refreshKeyPrefix = "refresh:"
+)
+
+// notFound is cached for ids and tokens that do not exist
+type notFound struct{}
+
+// CacheStats are the hit and miss counters of a CachedStorage
+type CacheStats struct {
+	ClientHits   uint64
+	ClientMisses uint64
+	AccessHits   uint64
+	AccessMisses uint64
+}
+
+// CachedStorage wraps a SQLStorage with a read-through cache for GetClient and
+// LoadAccess. The clients of loaded authorize and access data are read through
+// the cache as well. Entries are invalidated by SetClient, RemoveClient,
+// SaveAccess, RemoveAccess, RemoveRefresh, RevokeAccess, RevokeRefresh,
+// RevokeConsent and RevokeSubjectToken of the same CachedStorage, so writes made
+// through other instances are only seen once the entries expire.
+type CachedStorage struct {
+	*SQLStorage
+
+	cache       Cache
+	clientTTL   time.Duration
+	accessTTL   time.Duration
+	negativeTTL time.Duration
+
+	// generation is incremented by every invalidation, so that a load that raced
+	// with a write does not cache what it read before the write
+	mu         sync.Mutex
+	generation uint64
+
+	clientHits   uint64
+	clientMisses uint64
+	accessHits   uint64
+	accessMisses uint64
+}
+
+// CacheOption configures a CachedStorage
+type CacheOption func(*CachedStorage)
+
+// WithClientTTL sets how long clients are cached (DefaultClientTTL by default)
+func WithClientTTL(ttl time.Duration) CacheOption {
+	return func(store *CachedStorage) {
+		store.clientTTL = ttl
+	}
+}
+
+// WithAccessTTL sets how long access data is cached (DefaultAccessTTL by default).
+// Access data is never cached beyond its expiry.
+func WithAccessTTL(ttl time.Duration) CacheOption {
+	return func(store *CachedStorage) {
+		store.accessTTL = ttl
+	}
+}
+
+// WithNegativeTTL sets how long unknown client ids and access tokens are cached
+// (DefaultNegativeTTL by default). Zero disables negative caching.
+func WithNegativeTTL(ttl time.Duration) CacheOption {
+	return func(store *CachedStorage) {
+		store.negativeTTL = ttl
+	}
+}
+
+// NewCachedStorage wraps store with a read-through cache. store itself is not
+// modified and keeps reading from the database.
+func NewCachedStorage(store *SQLStorage, cache Cache, opts ...CacheOption) *CachedStorage {
+	cached := &CachedStorage{
+		cache:       cache,
+		clientTTL:   DefaultClientTTL,
+		accessTTL:   DefaultAccessTTL,
+		negativeTTL: DefaultNegativeTTL,
+	}
+	for _, opt := range opts {
+		opt(cached)
+	}
+
+	// Load the clients of authorize and access data through the cache
+	inner := *store
+	inner.clientLoader = cached.getClient
+	cached.SQLStorage = &inner
+	return cached
+}
+
+func (store *CachedStorage) Clone() osin.Storage {
+	return store
+}
+
+// Stats returns the hit and miss counters of the cache
+func (store *CachedStorage) Stats() CacheStats {
+	return CacheStats{
+		ClientHits:   atomic.LoadUint64(&store.clientHits),
+		ClientMisses: atomic.LoadUint64(&store.clientMisses),
+		AccessHits:   atomic.LoadUint64(&store.accessHits),
+		AccessMisses: atomic.LoadUint64(&store.accessMisses),
+	}
+}
+
+func (store *CachedStorage) GetClient(id string) (osin.Client, error) {
+	return store.getClient(context.Background(), id)
+}
+
+// getClient reads a client through the cache. ctx is passed to the database on a
+// cache miss.
+func (store *CachedStorage) getClient(ctx context.Context, id string) (osin.Client, error) {
+	if value, ok := store.cache.Get(store.key(clientKeyPrefix, id)); ok {
+		atomic.AddUint64(&store.clientHits, 1)
+		store.cacheResult("client", true)
+		if _, ok := value.(notFound); ok {
+			return nil, sql.ErrNoRows
+		}
+		return value.(osin.Client), nil
+	}
+	atomic.AddUint64(&store.clientMisses, 1)
+	store.cacheResult("client", false)
+
+	generation := store.currentGeneration()
+	client, err := store.SQLStorage.getClient(ctx, id)
+	if err == sql.ErrNoRows {
+		store.setNotFound(generation, store.key(clientKeyPrefix, id))
+	}
+	if err != nil {
+		return nil, err
+	}
+	store.set(generation, store.key(clientKeyPrefix, id), client, store.clientTTL)
+	return client, nil
+}
+
+func (store *CachedStorage) SetClient(client osin.Client) error {
+	defer store.invalidate(store.key(clientKeyPrefix, client.GetId()))
+	return store.SQLStorage.SetClient(client)
+}
+
+func (store *CachedStorage) RemoveClient(id string) error {
+	defer store.invalidate(store.key(clientKeyPrefix, id))
+	return store.SQLStorage.RemoveClient(id)
+}
+
+func (store *CachedStorage) SaveAccess(accessData *osin.AccessData) error {
+	// The token may be cached as not found
+	defer store.invalidate(store.key(accessKeyPrefix, accessData.AccessToken))
+	return store.SQLStorage.SaveAccess(accessData)
+}
+
+// LoadAccess returns a copy of the cached access data, so that changes of the
+// caller are not seen by other callers. Its client is read through the client
+// cache on every call, so that changed and removed clients are seen.
+func (store *CachedStorage) LoadAccess(token string) (*osin.AccessData, error) {
+	if value, ok := store.cache.Get(store.key(accessKeyPrefix, token)); ok {
+		atomic.AddUint64(&store.accessHits, 1)
+		store.cacheResult("access", true)
+		if _, ok := value.(notFound); ok {
+			return nil, sql.ErrNoRows
+		}
+
+		accessData := copyAccessData(value.(*osin.AccessData))
+		client, err := store.GetClient(accessData.Client.GetId())
+		if err != nil {
+			return nil, err
+		}
+		accessData.Client = client
+		return accessData, nil
+	}
+	atomic.AddUint64(&store.accessMisses, 1)
+	store.cacheResult("access", false)
+
+	generation := store.currentGeneration()
+	accessData, err := store.SQLStorage.LoadAccess(token)
+	if err == sql.ErrNoRows {
+		store.setNotFound(generation, store.key(accessKeyPrefix, token))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := store.accessTTL
+	if remaining := time.Until(accessData.ExpireAt()); remaining < ttl {
+		ttl = remaining
+	}
+	if ttl > 0 && store.set(generation, store.key(accessKeyPrefix, token), copyAccessData(accessData), ttl) &&
+		accessData.RefreshToken != "" {
+		store.set(generation, store.key(refreshKeyPrefix, accessData.RefreshToken), token, ttl)
+	}
+	return accessData, nil
+}
+
+func (store *CachedStorage) RemoveAccess(token string) error {
+	defer store.invalidate(store.key(accessKeyPrefix, token))
+	return store.SQLStorage.RemoveAccess(token)
+}
+
+// RevokeAccess revokes an access token, including its cached access data
+func (store *CachedStorage) RevokeAccess(ctx context.Context, token, reason string) error {
+	defer store.invalidate(store.key(accessKeyPrefix, token))
+	return store.SQLStorage.RevokeAccess(ctx, token, reason)
+}
+
+// RevokeConsent revokes a consent, including the cached access data of the
+// removed tokens
+func (store *CachedStorage) RevokeConsent(ctx context.Context, subject, clientID string, revokeTokens bool) (err error) {
+	ctx, op := store.begin(ctx, "RevokeConsent")
+	defer op.end(&err)
+	tokens, err := store.revokeConsent(ctx, subject, clientID, revokeTokens)
+	store.invalidateAccess(tokens)
+	return err
+}
+
+// RevokeSubjectToken revokes a token and its derived tokens, including their
+// cached access data
+func (store *CachedStorage) RevokeSubjectToken(ctx context.Context, accessToken string, cascade bool) (err error) {
+	ctx, op := store.begin(ctx, "RevokeSubjectToken")
+	defer op.end(&err)
+	tokens, err := store.revokeSubjectToken(ctx, accessToken, cascade)
+	store.invalidateAccess(tokens)
+	return err
+}
+
+// RemoveRefresh removes the access data of a refresh token, including the cached
+// access data of its access token
+func (store *CachedStorage) RemoveRefresh(token string) error {
+	return store.removeRefresh(context.Background(), token, func() error {
+		return store.SQLStorage.RemoveRefresh(token)
+	})
+}
+
+// RevokeRefresh revokes the access data of a refresh token, including the cached
+// access data of its access token
+func (store *CachedStorage) RevokeRefresh(ctx context.Context, token, reason string) error {
+	return store.removeRefresh(ctx, token, func() error {
+		return store.SQLStorage.RevokeRefresh(ctx, token, reason)
+	})
+}
+
+// removeRefresh calls remove and deletes the cached access data of a refresh token
+func (store *CachedStorage) removeRefresh(ctx context.Context, token string, remove func() error) error {
+	accessToken, ok := store.cache.Get(store.key(refreshKeyPrefix, token))
+	if !ok {
+		// The refresh token may have been evicted before its access token
+		dbToken, err := store.refreshAccessToken(ctx, token)
+		if err != nil {
+			return err
+		}
+		accessToken = dbToken
+	}
+
+	defer store.invalidate(store.key(accessKeyPrefix, accessToken.(string)), store.key(refreshKeyPrefix, token))
+	return remove()
+}
+
+// key returns the cache key of an id. Keys include the tenant, so that tenants
+// can share a cache.
+func (store *CachedStorage) key(prefix, id string) string {
+	return prefix + strconv.Quote(store.tenantID) + id
+}
+
+// currentGeneration returns the generation to pass to set after a load
+func (store *CachedStorage) currentGeneration() uint64 {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.generation
+}
+
+// set caches value for key unless an invalidation happened since generation was
+// read, and reports whether it was cached
+func (store *CachedStorage) set(generation uint64, key string, value interface{}, ttl time.Duration) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.generation != generation {
+		return false
+	}
+	store.cache.Set(key, value, ttl)
+	return true
+}
+
+// invalidate removes keys from the cache and discards the results of running loads
+func (store *CachedStorage) invalidate(keys ...string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.generation++
+	for _, key := range keys {
+		store.cache.Delete(key)
+	}
+}
+
+// invalidateAccess removes the cached access data of tokens
+func (store *CachedStorage) invalidateAccess(tokens []string) {
+	keys := make([]string, len(tokens))
+	for i, token := range tokens {
+		keys[i] = store.key(accessKeyPrefix, token)
+	}
+	store.invalidate(keys...)
+}
+
+// setNotFound caches that key does not exist if negative caching is enabled
+func (store *CachedStorage) setNotFound(generation uint64, key string) {
+	if store.negativeTTL > 0 {
+		store.set(generation, key, notFound{}, store.negativeTTL)
+	}
+}
+
+// copyAccessData copies access data with its authorize data, previous access
+// data and user data, so that the copy can be modified. Registered authorization
+// detail values are shared.
+func copyAccessData(accessData *osin.AccessData) *osin.AccessData {
+	data := *accessData
+	data.UserData = copyUserData(data.UserData)
+	if data.AuthorizeData != nil {
+		authorizeData := *data.AuthorizeData
+		authorizeData.UserData = copyUserData(authorizeData.UserData)
+		data.AuthorizeData = &authorizeData
+	}
+	if data.AccessData != nil {
+		data.AccessData = copyAccessData(data.AccessData)
+	}
+	return &data
+}
+
+// copyUserData copies loaded user data, which is decoded json, optionally wrapped
+// in ExtendedUserData
+func copyUserData(userData interface{}) interface{} {
+	switch value := userData.(type) {
+	case *ExtendedUserData:
+		if value == nil {
+			return value
+		}
+		ext := *value
+		ext.UserData = copyUserData(ext.UserData)
+		ext.Audience = append([]string(nil), ext.Audience...)
+		ext.AuthorizationDetails = append([]AuthorizationDetail(nil), ext.AuthorizationDetails...)
+		if ext.OIDC != nil {
+			oidc := *ext.OIDC
+			oidc.AMR = append([]string(nil), oidc.AMR...)
+			oidc.Claims = append(json.RawMessage(nil), oidc.Claims...)
+			if oidc.AuthTime != nil {
+				authTime := *oidc.AuthTime
+				oidc.AuthTime = &authTime
+			}
+			ext.OIDC = &oidc
+		}
+		if ext.Confirmation != nil {
+			confirmation := *ext.Confirmation
+			ext.Confirmation = &confirmation
+		}
+		if ext.TokenExchange != nil {
+			exchange := *ext.TokenExchange
+			ext.TokenExchange = &exchange
+		}
+		return &ext
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			copied[k] = copyUserData(v)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, v := range value {
+			copied[i] = copyUserData(v)
+		}
+		return copied
+	default:
+		return value
+	}
+}
@@ -0,0 +1,191 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/RangelReale/osin"
+	"testing"
+	"time"
+)
+
+func TestCachedStorage(t *testing.T) {
+	store := NewCachedStorage(testingContext.Store, NewLRUCache(100))
+
+	client := &osin.DefaultClient{Id: "cachedclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	defer store.RemoveClient(client.Id)
+
+	for i := 0; i < 2; i++ {
+		if _, err := store.GetClient(client.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := store.Stats(); stats.ClientHits != 1 || stats.ClientMisses != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	accessData := &osin.AccessData{AccessToken: "cachedaccess", RefreshToken: "cachedrefresh", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client, UserData: userData[0]}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	defer store.RemoveAccess(accessData.AccessToken)
+
+	// The client of the loaded access data is read through the cache
+	for i := 0; i < 2; i++ {
+		retAccessData, err := store.LoadAccess(accessData.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if retAccessData.Client.GetId() != client.Id {
+			t.Errorf("\"%v\": expected %v", retAccessData.Client.GetId(), client.Id)
+		}
+	}
+	if stats := store.Stats(); stats.AccessHits != 1 || stats.AccessMisses != 1 || stats.ClientMisses != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// Removing the refresh token invalidates the cached access data
+	if err := store.RemoveRefresh(accessData.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+
+	// Saving a token that is cached as not found invalidates the negative entry
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); err != nil {
+		t.Error(err)
+	}
+	if err := store.RemoveAccess(accessData.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestCachedStorageNegativeCaching(t *testing.T) {
+	store := NewCachedStorage(testingContext.Store, NewLRUCache(100))
+
+	for i := 0; i < 2; i++ {
+		if _, err := store.GetClient("missingcachedclient"); err != sql.ErrNoRows {
+			t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+		}
+	}
+	if stats := store.Stats(); stats.ClientHits != 1 || stats.ClientMisses != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// Writes through the cached storage invalidate the negative entry
+	client := &osin.DefaultClient{Id: "missingcachedclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetClient(client.Id); err != nil {
+		t.Error(err)
+	}
+	if err := store.RemoveClient(client.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetClient(client.Id); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+
+	// Without negative caching every lookup of a missing client is a miss
+	store = NewCachedStorage(testingContext.Store, NewLRUCache(100), WithNegativeTTL(0))
+	for i := 0; i < 2; i++ {
+		store.GetClient("missingcachedclient")
+	}
+	if stats := store.Stats(); stats.ClientHits != 0 || stats.ClientMisses != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestCachedStorageRevocations(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewCachedStorage(NewSQLStorage(db), NewLRUCache(100))
+	client := &osin.DefaultClient{Id: "cachedrevoke", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	for _, accessData := range []*osin.AccessData{
+		{AccessToken: "cachedconsent", UserData: &ExtendedUserData{Subject: "alice"}},
+		{AccessToken: "cachedsubject", UserData: &ExtendedUserData{Subject: "bob"}},
+		{AccessToken: "cachedderived", UserData: &ExtendedUserData{Subject: "bob",
+			TokenExchange: &TokenExchange{SubjectToken: "cachedsubject"}}},
+	} {
+		accessData.ExpiresIn = 100
+		accessData.CreatedAt = time.Now()
+		accessData.Client = client
+		if err := store.SaveAccess(accessData); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.LoadAccess(accessData.AccessToken); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Bulk revocations invalidate the cached access data of the revoked tokens
+	if err := store.RevokeConsent(ctx, "alice", client.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeSubjectToken(ctx, "cachedsubject", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"cachedconsent", "cachedsubject", "cachedderived"} {
+		if _, err := store.LoadAccess(token); err != sql.ErrNoRows {
+			t.Errorf("%s: \"%v\": expected %v", token, err, sql.ErrNoRows)
+		}
+	}
+}
+
+func TestCachedStorageCopies(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewCachedStorage(NewSQLStorage(db), NewLRUCache(100))
+	client := &osin.DefaultClient{Id: "cachedcopy", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "cachedcopy", ExpiresIn: 100, CreatedAt: time.Now(), Client: client,
+		UserData: &ExtendedUserData{UserData: map[string]interface{}{"role": "user"}, Audience: []string{"api"}}}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes of the loaded access data are not seen by later loads
+	for i := 0; i < 2; i++ {
+		loaded, err := store.LoadAccess(accessData.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ext := loaded.UserData.(*ExtendedUserData)
+		if role := ext.UserData.(map[string]interface{})["role"]; role != "user" || ext.Audience[0] != "api" {
+			t.Errorf("%d: unexpected user data %+v", i, ext)
+		}
+		ext.UserData.(map[string]interface{})["role"] = "admin"
+		ext.Audience[0] = "admin"
+		loaded.Scope = "admin"
+	}
+	if stats := store.Stats(); stats.AccessHits != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// A load that raced with a write does not cache what it read before the write
+	generation := store.currentGeneration()
+	store.invalidate(store.key(accessKeyPrefix, "racedaccess"))
+	store.setNotFound(generation, store.key(accessKeyPrefix, "racedaccess"))
+	if _, ok := store.cache.Get(store.key(accessKeyPrefix, "racedaccess")); ok {
+		t.Error("Expected the stale not found entry to be discarded")
+	}
+}
@@ -0,0 +1,69 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/RangelReale/osin"
+	"testing"
+	"time"
+)
+
+func TestClientDB(t *testing.T) {
+	ctx := context.Background()
+	tokenDB := openMemoryDB(t)
+	defer tokenDB.Close()
+	clientDB := openMemoryDB(t)
+	defer clientDB.Close()
+
+	store := NewSQLStorage(tokenDB, WithClientDB(clientDB))
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &osin.DefaultClient{Id: "splitclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokenDB.Exec("SELECT id FROM clients"); err == nil {
+		t.Error("The token database should not have a clients table")
+	}
+
+	authData := &osin.AuthorizeData{Code: "splitcode", ExpiresIn: 100, RedirectUri: "redirect",
+		CreatedAt: time.Now(), Client: client}
+	if err := store.SaveAuthorize(authData); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "splitaccess", ExpiresIn: 100, CreatedAt: time.Now(),
+		Client: client, AuthorizeData: authData}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.GrantConsent(ctx, "alice", client.Id, []string{"read"}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	retAccessData, err := store.LoadAccess(accessData.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retAccessData.Client.GetId() != client.Id || retAccessData.AuthorizeData == nil {
+		t.Errorf("Unexpected access data %v", retAccessData)
+	}
+
+	// Removing the client removes its token data from the token database
+	if err := store.RemoveClient(client.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetClient(client.Id); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+	for _, table := range clientDataTables {
+		var count int
+		if err := tokenDB.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("%s: \"%v\": expected 0", table, count)
+		}
+	}
+}
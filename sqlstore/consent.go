@@ -0,0 +1,147 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Consent is a grant of scopes by an end user (the subject) to a client
+type Consent struct {
+	Subject   string
+	ClientID  string
+	Scopes    []string
+	GrantedAt time.Time
+	// ExpiresAt is the zero time if the consent does not expire
+	ExpiresAt time.Time
+}
+
+// expired reports whether the consent has expired at now
+func (consent *Consent) expired(now time.Time) bool {
+	return !consent.ExpiresAt.IsZero() && !now.Before(consent.ExpiresAt)
+}
+
+// GrantConsent remembers that subject granted scopes to a client until expiry. A zero
+// expiry never expires. Any previous consent of subject for the client is replaced.
+func (store *SQLStorage) GrantConsent(ctx context.Context, subject, clientID string, scopes []string, expiry time.Time) (err error) {
+	ctx, op := store.begin(ctx, "GrantConsent")
+	defer op.end(&err)
+	var expiresAt interface{}
+	if !expiry.IsZero() {
+		expiresAt = expiry
+	}
+
+	tx, err := store.authDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = store.exec(ctx, tx, store.rebind(
+		"DELETE FROM consents WHERE tenant_id = ? AND subject = ? AND client_id = ?"), store.tenantID, subject, clientID)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.exec(ctx, tx, store.rebind(`
+		INSERT INTO consents(tenant_id, subject, client_id, scope, granted_at, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?)
+		`), store.tenantID, subject, clientID, strings.Join(scopes, " "), store.now(), expiresAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// HasConsent reports whether subject has an unexpired consent for the client that
+// covers all of the requested scopes
+func (store *SQLStorage) HasConsent(ctx context.Context, subject, clientID string, requestedScopes []string) (_ bool, err error) {
+	ctx, op := store.begin(ctx, "HasConsent")
+	defer op.end(&err)
+	consents, err := store.loadConsents(ctx, "subject = ? AND client_id = ?", subject, clientID)
+	if err != nil || len(consents) == 0 {
+		return false, err
+	}
+
+	return containsAll(consents[0].Scopes, requestedScopes), nil
+}
+
+// ListConsents returns the unexpired consents that subject has granted
+func (store *SQLStorage) ListConsents(ctx context.Context, subject string) (_ []*Consent, err error) {
+	ctx, op := store.begin(ctx, "ListConsents")
+	defer op.end(&err)
+	return store.loadConsents(ctx, "subject = ?", subject)
+}
+
+// RevokeConsent removes the consent of subject for the client. If revokeTokens is
+// set, the access data issued to subject for the client is removed as well, or
+// revoked with WithSoftDelete, running the RemoveAccess hooks for each token.
+func (store *SQLStorage) RevokeConsent(ctx context.Context, subject, clientID string, revokeTokens bool) (err error) {
+	ctx, op := store.begin(ctx, "RevokeConsent")
+	defer op.end(&err)
+	_, err = store.revokeConsent(ctx, subject, clientID, revokeTokens)
+	return err
+}
+
+// revokeConsent removes the consent and returns the removed access tokens
+func (store *SQLStorage) revokeConsent(ctx context.Context, subject, clientID string, revokeTokens bool) ([]string, error) {
+	tx, err := store.authDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = store.exec(ctx, tx, store.rebind(
+		"DELETE FROM consents WHERE tenant_id = ? AND subject = ? AND client_id = ?"), store.tenantID, subject, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []string{}
+	if revokeTokens {
+		tokens, err = store.queryStrings(ctx, tx, store.rebind(
+			"SELECT access_token FROM access_data WHERE tenant_id = ? AND subject = ? AND client_id = ?"+
+				notRevoked("access_data")), store.tenantID, subject, clientID)
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range tokens {
+			if err := store.removeAccessTx(ctx, tx, token, store.softDelete, RevokedReasonConsentRevoked); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tokens, tx.Commit()
+}
+
+// loadConsents loads the unexpired consents matching the where clause
+func (store *SQLStorage) loadConsents(ctx context.Context, where string, args ...interface{}) ([]*Consent, error) {
+	rows, err := store.query(ctx, store.authDB, store.rebind(`
+		SELECT subject, client_id, scope, granted_at, expires_at FROM consents
+		WHERE tenant_id = ? AND `+where+` ORDER BY client_id`), append([]interface{}{store.tenantID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := store.now()
+	consents := []*Consent{}
+	for rows.Next() {
+		var (
+			consent   Consent
+			scope     string
+			expiresAt sql.NullTime
+		)
+		if err := rows.Scan(&consent.Subject, &consent.ClientID, &scope, &consent.GrantedAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		consent.Scopes = strings.Fields(scope)
+		consent.ExpiresAt = expiresAt.Time
+
+		if !consent.expired(now) {
+			consents = append(consents, &consent)
+		}
+	}
+	return consents, rows.Err()
+}
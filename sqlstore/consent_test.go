@@ -0,0 +1,82 @@
+package sqlstore
+
+import (
+	"context"
+	"github.com/RangelReale/osin"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConsent(t *testing.T) {
+	ctx := context.Background()
+	store := testingContext.Store
+
+	if err := store.GrantConsent(ctx, "alice", "consentclient", []string{"read", "write"}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.GrantConsent(ctx, "alice", "expiredclient", []string{"read"}, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	defer store.RevokeConsent(ctx, "alice", "expiredclient", false)
+
+	tests := []struct {
+		clientID string
+		scopes   []string
+		expected bool
+	}{
+		{"consentclient", []string{"read"}, true},
+		{"consentclient", []string{"write", "read"}, true},
+		{"consentclient", []string{}, true},
+		{"consentclient", []string{"read", "admin"}, false},
+		{"expiredclient", []string{"read"}, false},
+		{"otherclient", []string{"read"}, false},
+	}
+	for _, test := range tests {
+		ok, err := store.HasConsent(ctx, "alice", test.clientID, test.scopes)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok != test.expected {
+			t.Errorf("%s %v: \"%v\": expected %v", test.clientID, test.scopes, ok, test.expected)
+		}
+	}
+
+	// Granting again replaces the scopes
+	if err := store.GrantConsent(ctx, "alice", "consentclient", []string{"read"}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	consents, err := store.ListConsents(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(consents) != 1 || consents[0].ClientID != "consentclient" || !reflect.DeepEqual(consents[0].Scopes, []string{"read"}) {
+		t.Errorf("Unexpected consents %v", consents)
+	}
+
+	// Revoking the consent revokes the tokens of the subject for the client
+	client := &osin.DefaultClient{Id: "consentclient", Secret: "secret", RedirectUri: "redirect"}
+	store.SetClient(client)
+	defer store.RemoveClient(client.Id)
+	for _, subject := range []string{"alice", "bob"} {
+		accessData := &osin.AccessData{AccessToken: "consent" + subject, ExpiresIn: 100, Scope: "read",
+			CreatedAt: time.Now(), Client: client, UserData: &ExtendedUserData{Subject: subject}}
+		if err := store.SaveAccess(accessData); err != nil {
+			t.Fatal(err)
+		}
+		defer store.RemoveAccess(accessData.AccessToken)
+	}
+
+	if err := store.RevokeConsent(ctx, "alice", "consentclient", true); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := store.HasConsent(ctx, "alice", "consentclient", []string{"read"}); ok {
+		t.Error("Consent should be revoked")
+	}
+	if accessData, _, _, _, _ := store.loadAccess(ctx, store.authDB, "consentalice", false); accessData != nil {
+		t.Error("Access data of the subject should be revoked")
+	}
+	if accessData, _, _, _, _ := store.loadAccess(ctx, store.authDB, "consentbob", false); accessData == nil {
+		t.Error("Access data of other subjects should not be revoked")
+	}
+}
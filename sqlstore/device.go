@@ -0,0 +1,268 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// DeviceCodeStatus is the state of a device authorization request
+type DeviceCodeStatus string
+
+const (
+	DeviceCodePending  DeviceCodeStatus = "pending"
+	DeviceCodeApproved DeviceCodeStatus = "approved"
+	DeviceCodeDenied   DeviceCodeStatus = "denied"
+)
+
+// Errors returned by PollDeviceCode. Their messages are the error codes of RFC 8628.
+var (
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrAccessDenied         = errors.New("access_denied")
+	ErrExpiredToken         = errors.New("expired_token")
+)
+
+// slowDownIncrement is added to the polling interval of a device code every time
+// the client polls too fast
+const slowDownIncrement = 5
+
+// DeviceCode is a device authorization request (RFC 8628)
+type DeviceCode struct {
+	DeviceCode string
+	UserCode   string
+	ClientID   string
+	Scope      string
+	ExpiresIn  int32
+	// Interval is the minimum number of seconds between polls
+	Interval  int32
+	CreatedAt time.Time
+	Status    DeviceCodeStatus
+
+	// Subject and UserData are set when the end user approves the request
+	Subject  string
+	UserData interface{}
+
+	LastPolledAt time.Time
+}
+
+// normalizeUserCode upper-cases a user code and removes its separators, so that
+// the end user does not have to enter it in the displayed format
+func normalizeUserCode(userCode string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(userCode))
+}
+
+// IsExpiredAt is true if the device code is expired at t
+func (dc *DeviceCode) IsExpiredAt(t time.Time) bool {
+	return dc.CreatedAt.Add(time.Duration(dc.ExpiresIn) * time.Second).Before(t)
+}
+
+// SaveDeviceCode saves a new device authorization request. The status is always saved as pending.
+// User codes are stored normalized and must be unique per tenant.
+func (store *SQLStorage) SaveDeviceCode(ctx context.Context, dc *DeviceCode) (err error) {
+	ctx, op := store.begin(ctx, "SaveDeviceCode")
+	defer op.end(&err)
+	dc.Status = DeviceCodePending
+
+	_, err = store.exec(ctx, store.authDB, store.rebind(`
+		INSERT INTO device_codes(tenant_id, device_code, user_code, client_id, scope, expires_in, poll_interval,
+		created_at, status)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`), store.tenantID, dc.DeviceCode, normalizeUserCode(dc.UserCode), dc.ClientID, dc.Scope, dc.ExpiresIn, dc.Interval, dc.CreatedAt, string(dc.Status))
+	return err
+}
+
+// LoadByUserCode loads the device authorization request for the code entered by the
+// end user, ignoring case and separators. It returns ErrExpiredToken if the request
+// has expired.
+func (store *SQLStorage) LoadByUserCode(ctx context.Context, userCode string) (_ *DeviceCode, err error) {
+	ctx, op := store.begin(ctx, "LoadByUserCode")
+	defer op.end(&err)
+	dc, err := store.loadDeviceCode(ctx, "user_code", normalizeUserCode(userCode))
+	if err != nil {
+		return nil, err
+	}
+	if dc.IsExpiredAt(store.now()) {
+		return nil, ErrExpiredToken
+	}
+	return dc, nil
+}
+
+// ApproveDeviceCode approves the pending request for userCode on behalf of subject. The
+// user data is attached to the access data issued to the device. It returns
+// ErrExpiredToken if the request has expired.
+func (store *SQLStorage) ApproveDeviceCode(ctx context.Context, userCode, subject string, userData interface{}) (err error) {
+	ctx, op := store.begin(ctx, "ApproveDeviceCode")
+	defer op.end(&err)
+	userDataStr, err := setUserData(userData)
+	if err != nil {
+		return err
+	}
+	return store.setDeviceCodeStatus(ctx, userCode, DeviceCodeApproved, nullString(subject), store.userDataArg(userDataStr))
+}
+
+// DenyDeviceCode denies the pending request for userCode. It returns ErrExpiredToken
+// if the request has expired.
+func (store *SQLStorage) DenyDeviceCode(ctx context.Context, userCode string) (err error) {
+	ctx, op := store.begin(ctx, "DenyDeviceCode")
+	defer op.end(&err)
+	return store.setDeviceCodeStatus(ctx, userCode, DeviceCodeDenied, nil, nil)
+}
+
+// setDeviceCodeStatus sets the status of a pending device code that has not expired.
+// It returns ErrExpiredToken if the device code has expired and sql.ErrNoRows if there
+// is no pending device code for userCode.
+func (store *SQLStorage) setDeviceCodeStatus(ctx context.Context, userCode string, status DeviceCodeStatus, subject, userData interface{}) error {
+	userCode = normalizeUserCode(userCode)
+	result, err := store.exec(ctx, store.authDB, store.rebind(`
+		UPDATE device_codes SET status = ?, subject = ?, user_data = ?
+		WHERE tenant_id = ? AND user_code = ? AND status = ? AND `+store.dialect.addSeconds("created_at", "expires_in")+` >= ?
+		`), string(status), subject, userData, store.tenantID, userCode, string(DeviceCodePending), store.now())
+	if err != nil {
+		return err
+	}
+	if err := requireRowsAffected(result); err != sql.ErrNoRows {
+		return err
+	}
+
+	// Report expired device codes as such
+	dc, err := store.loadDeviceCode(ctx, "user_code", userCode)
+	if err == nil && dc.Status == DeviceCodePending && dc.IsExpiredAt(store.now()) {
+		return ErrExpiredToken
+	}
+	return sql.ErrNoRows
+}
+
+// PollDeviceCode is called when a client polls the token endpoint with a device code.
+// It returns the approved request, or one of ErrAuthorizationPending, ErrSlowDown,
+// ErrAccessDenied and ErrExpiredToken. Polling faster than the interval increases the
+// interval. The approved request is not consumed until ConsumeDeviceCode is called
+// once its access token is saved.
+func (store *SQLStorage) PollDeviceCode(ctx context.Context, deviceCode, clientID string) (_ *DeviceCode, err error) {
+	ctx, op := store.begin(ctx, "PollDeviceCode")
+	defer op.end(&err)
+	dc, err := store.loadDeviceCode(ctx, "device_code", deviceCode)
+	if err != nil {
+		return nil, err
+	}
+	if dc.ClientID != clientID {
+		return nil, sql.ErrNoRows
+	}
+
+	now := store.now()
+	if dc.IsExpiredAt(now) {
+		return nil, ErrExpiredToken
+	}
+
+	// Remember the poll unless it came too soon. The condition makes concurrent polls
+	// of the same device code see each other, so only one of them gets past it.
+	result, err := store.exec(ctx, store.authDB, store.rebind(`
+		UPDATE device_codes SET last_polled_at = ?
+		WHERE tenant_id = ? AND device_code = ? AND (last_polled_at IS NULL OR last_polled_at <= ?)`),
+		now, store.tenantID, deviceCode, now.Add(-time.Duration(dc.Interval)*time.Second))
+	if err != nil {
+		return nil, err
+	}
+	if err := requireRowsAffected(result); err == sql.ErrNoRows {
+		// Slow the client down
+		_, err = store.exec(ctx, store.authDB, store.rebind(
+			"UPDATE device_codes SET poll_interval = poll_interval + ?, last_polled_at = ? WHERE tenant_id = ? AND device_code = ?"),
+			slowDownIncrement, now, store.tenantID, deviceCode)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrSlowDown
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch dc.Status {
+	case DeviceCodePending:
+		return nil, ErrAuthorizationPending
+	case DeviceCodeDenied:
+		return nil, ErrAccessDenied
+	}
+	return dc, nil
+}
+
+// ConsumeDeviceCode deletes an approved device code once its access token is saved,
+// so that it can only be exchanged for a token once. It returns sql.ErrNoRows if
+// the device code is not approved or was already consumed.
+func (store *SQLStorage) ConsumeDeviceCode(ctx context.Context, deviceCode string) (err error) {
+	ctx, op := store.begin(ctx, "ConsumeDeviceCode")
+	defer op.end(&err)
+	result, err := store.exec(ctx, store.authDB, store.rebind(
+		"DELETE FROM device_codes WHERE tenant_id = ? AND device_code = ? AND status = ?"),
+		store.tenantID, deviceCode, string(DeviceCodeApproved))
+	if err != nil {
+		return err
+	}
+	return requireRowsAffected(result)
+}
+
+// RemoveDeviceCode deletes a device authorization request
+func (store *SQLStorage) RemoveDeviceCode(ctx context.Context, deviceCode string) (err error) {
+	ctx, op := store.begin(ctx, "RemoveDeviceCode")
+	defer op.end(&err)
+	tx, err := store.authDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = store.recordRemoval(ctx, tx, AuditDeviceCodeRemoved, "device_codes", "device_code", deviceCode)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.exec(ctx, tx, store.rebind(
+		"DELETE FROM device_codes WHERE tenant_id = ? AND device_code = ?"), store.tenantID, deviceCode)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadDeviceCode loads the device code whose key column has the given value
+func (store *SQLStorage) loadDeviceCode(ctx context.Context, key, value string) (*DeviceCode, error) {
+	var (
+		dc           DeviceCode
+		status       string
+		subject      sql.NullString
+		userDataStr  sql.NullString
+		lastPolledAt sql.NullTime
+	)
+
+	row := store.queryRow(ctx, store.authDB, store.rebind(`
+		SELECT device_code, user_code, client_id, scope, expires_in, poll_interval, created_at, status,
+		subject, user_data, last_polled_at
+		FROM device_codes WHERE tenant_id = ? AND `+key+` = ?`), store.tenantID, value)
+	err := row.Scan(&dc.DeviceCode, &dc.UserCode, &dc.ClientID, &dc.Scope, &dc.ExpiresIn, &dc.Interval,
+		&dc.CreatedAt, &status, &subject, &userDataStr, &lastPolledAt)
+	if err != nil {
+		return nil, err
+	}
+
+	dc.UserData, err = getUserData(userDataStr.String)
+	if err != nil {
+		return nil, err
+	}
+	dc.Status = DeviceCodeStatus(status)
+	dc.Subject = subject.String
+	dc.LastPolledAt = lastPolledAt.Time
+	return &dc, nil
+}
+
+// requireRowsAffected returns sql.ErrNoRows if a statement did not change any rows
+func requireRowsAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
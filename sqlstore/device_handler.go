@@ -0,0 +1,191 @@
+package sqlstore
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"github.com/RangelReale/osin"
+	"net/http"
+)
+
+// DeviceCodeGrantType is the grant_type of device access token requests
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// userCodeAlphabet leaves out vowels and easily confused characters (RFC 8628 section 6.1)
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// DeviceFlow serves the device authorization endpoint and the device access token
+// request of the device authorization grant (RFC 8628). Access tokens are issued with
+// the token generator and configuration of Server and saved with its storage.
+type DeviceFlow struct {
+	Server *osin.Server
+	Store  *SQLStorage
+
+	// VerificationURI is where the end user enters the user code
+	VerificationURI string
+	// ExpiresIn is the lifetime of device codes in seconds
+	ExpiresIn int32
+	// Interval is the initial number of seconds clients must wait between polls
+	Interval int32
+}
+
+// NewDeviceFlow creates a device flow with a 10 minute device code lifetime and a 5
+// second polling interval
+func NewDeviceFlow(server *osin.Server, store *SQLStorage, verificationURI string) *DeviceFlow {
+	return &DeviceFlow{
+		Server:          server,
+		Store:           store,
+		VerificationURI: verificationURI,
+		ExpiresIn:       600,
+		Interval:        5,
+	}
+}
+
+// HandleDeviceAuthorization handles a device authorization request and issues a new
+// device code and user code to the authenticated client
+func (flow *DeviceFlow) HandleDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	resp := flow.Server.NewResponse()
+	defer resp.Close()
+	defer osin.OutputJSON(resp, w, r)
+
+	if r.Method != "POST" {
+		resp.SetError(osin.E_INVALID_REQUEST, "")
+		return
+	}
+	client := authenticateClient(resp, r)
+	if client == nil {
+		return
+	}
+
+	deviceCode, err := randomToken(32)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return
+	}
+
+	dc := &DeviceCode{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ClientID:   client.GetId(),
+		Scope:      r.FormValue("scope"),
+		ExpiresIn:  flow.ExpiresIn,
+		Interval:   flow.Interval,
+		CreatedAt:  flow.Server.Now(),
+	}
+	if err := flow.Store.SaveDeviceCode(r.Context(), dc); err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return
+	}
+
+	resp.Output["device_code"] = dc.DeviceCode
+	resp.Output["user_code"] = dc.UserCode
+	resp.Output["verification_uri"] = flow.VerificationURI
+	resp.Output["expires_in"] = dc.ExpiresIn
+	resp.Output["interval"] = dc.Interval
+}
+
+// HandleDeviceToken handles an access token request with the device code grant type.
+// Once the end user approved the request, the access data is saved with SaveAccess
+// and the device code is consumed. The access data is removed again if the device
+// code was consumed by a concurrent request in the meantime.
+func (flow *DeviceFlow) HandleDeviceToken(w http.ResponseWriter, r *http.Request) {
+	resp := flow.Server.NewResponse()
+	defer resp.Close()
+	defer osin.OutputJSON(resp, w, r)
+
+	if r.Method != "POST" {
+		resp.SetError(osin.E_INVALID_REQUEST, "")
+		return
+	}
+	if r.FormValue("grant_type") != DeviceCodeGrantType {
+		resp.SetError(osin.E_UNSUPPORTED_GRANT_TYPE, "")
+		return
+	}
+	client := authenticateClient(resp, r)
+	if client == nil {
+		return
+	}
+
+	dc, err := flow.Store.PollDeviceCode(r.Context(), r.FormValue("device_code"), client.GetId())
+	switch err {
+	case nil:
+	case ErrAuthorizationPending, ErrSlowDown, ErrAccessDenied, ErrExpiredToken:
+		resp.SetError(err.Error(), "")
+		return
+	default:
+		resp.SetError(osin.E_INVALID_GRANT, "")
+		resp.InternalError = err
+		return
+	}
+
+	ret := &osin.AccessData{
+		Client:      client,
+		RedirectUri: client.GetRedirectUri(),
+		CreatedAt:   flow.Server.Now(),
+		ExpiresIn:   flow.Server.Config.AccessExpiration,
+		Scope:       dc.Scope,
+		UserData:    joinUserData(dc.UserData, &ExtendedUserData{Subject: dc.Subject}),
+	}
+	ret.AccessToken, ret.RefreshToken, err = flow.Server.AccessTokenGen.GenerateAccessToken(ret, true)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return
+	}
+	if err := resp.Storage.SaveAccess(ret); err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return
+	}
+	if err := flow.Store.ConsumeDeviceCode(r.Context(), dc.DeviceCode); err != nil {
+		if err == sql.ErrNoRows {
+			resp.SetError(osin.E_INVALID_GRANT, "")
+		} else {
+			resp.SetError(osin.E_SERVER_ERROR, "")
+		}
+		resp.InternalError = err
+		if err := resp.Storage.RemoveAccess(ret.AccessToken); err != nil {
+			resp.InternalError = err
+		}
+		return
+	}
+
+	resp.Output["access_token"] = ret.AccessToken
+	resp.Output["token_type"] = flow.Server.Config.TokenType
+	resp.Output["expires_in"] = ret.ExpiresIn
+	if ret.RefreshToken != "" {
+		resp.Output["refresh_token"] = ret.RefreshToken
+	}
+	if ret.Scope != "" {
+		resp.Output["scope"] = ret.Scope
+	}
+}
+
+// newUserCode returns a random user code in the form XXXX-XXXX
+func newUserCode() (string, error) {
+	// Random bytes of at least maxByte are skipped to keep the characters uniformly distributed
+	maxByte := 256 - 256%len(userCodeAlphabet)
+
+	code := make([]byte, 0, 9)
+	b := make([]byte, 1)
+	for len(code) < 9 {
+		if len(code) == 4 {
+			code = append(code, '-')
+			continue
+		}
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		if int(b[0]) < maxByte {
+			code = append(code, userCodeAlphabet[int(b[0])%len(userCodeAlphabet)])
+		}
+	}
+	return string(code), nil
+}
@@ -0,0 +1,157 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/RangelReale/osin"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postForm posts a form to handler with basic auth and decodes the json response
+func postForm(t *testing.T, handler http.HandlerFunc, form url.Values, clientID, secret string) map[string]interface{} {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, secret)
+
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	output := map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &output); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+	return output
+}
+
+func TestDeviceFlow(t *testing.T) {
+	ctx := context.Background()
+	client := &osin.DefaultClient{Id: "deviceflowclient", Secret: "secret", RedirectUri: "http://localhost:14001/appauth"}
+	if err := testingContext.Store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveClient(client.Id)
+
+	server := osin.NewServer(osin.NewServerConfig(), testingContext.Store)
+	flow := NewDeviceFlow(server, testingContext.Store, "http://localhost:14000/device")
+
+	// Unauthenticated clients can not request a device code
+	output := postForm(t, flow.HandleDeviceAuthorization, url.Values{"scope": {"read"}}, client.Id, "wrong")
+	if output["error"] != osin.E_INVALID_CLIENT {
+		t.Errorf("\"%v\": expected %v", output["error"], osin.E_INVALID_CLIENT)
+	}
+
+	output = postForm(t, flow.HandleDeviceAuthorization, url.Values{"scope": {"read"}}, client.Id, client.Secret)
+	deviceCode, _ := output["device_code"].(string)
+	userCode, _ := output["user_code"].(string)
+	if deviceCode == "" || len(userCode) != 9 || output["verification_uri"] != flow.VerificationURI {
+		t.Fatalf("Unexpected device authorization response %v", output)
+	}
+	defer testingContext.Store.RemoveDeviceCode(ctx, deviceCode)
+
+	tokenForm := url.Values{"grant_type": {DeviceCodeGrantType}, "device_code": {deviceCode}}
+	output = postForm(t, flow.HandleDeviceToken, tokenForm, client.Id, client.Secret)
+	if output["error"] != ErrAuthorizationPending.Error() {
+		t.Errorf("\"%v\": expected %v", output["error"], ErrAuthorizationPending)
+	}
+
+	if err := testingContext.Store.ApproveDeviceCode(ctx, userCode, "deviceflowuser", nil); err != nil {
+		t.Fatal(err)
+	}
+	pretendWaited(t, deviceCode)
+
+	output = postForm(t, flow.HandleDeviceToken, tokenForm, client.Id, client.Secret)
+	accessToken, _ := output["access_token"].(string)
+	if accessToken == "" || output["refresh_token"] == nil || output["scope"] != "read" {
+		t.Fatalf("Unexpected token response %v", output)
+	}
+	defer testingContext.Store.RemoveAccess(accessToken)
+
+	accessData, err := testingContext.Store.LoadAccess(accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext, ok := accessData.UserData.(*ExtendedUserData); !ok || ext.Subject != "deviceflowuser" {
+		t.Errorf("\"%v\": expected subject deviceflowuser", accessData.UserData)
+	}
+}
+
+func TestDeviceFlowSaveFailure(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db)
+	client := &osin.DefaultClient{Id: "deviceflowclient", Secret: "secret", RedirectUri: "http://localhost:14001/appauth"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	flow := NewDeviceFlow(osin.NewServer(osin.NewServerConfig(), store), store, "http://localhost:14000/device")
+	output := postForm(t, flow.HandleDeviceAuthorization, url.Values{"scope": {"read"}}, client.Id, client.Secret)
+	deviceCode, _ := output["device_code"].(string)
+	userCode, _ := output["user_code"].(string)
+	if err := store.ApproveDeviceCode(ctx, userCode, "deviceflowuser", nil); err != nil {
+		t.Fatal(err)
+	}
+	waited := func() {
+		_, err := db.Exec("UPDATE device_codes SET last_polled_at = ? WHERE device_code = ?",
+			time.Now().Add(-time.Hour).UTC(), deviceCode)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failed save keeps the approval
+	var failSave, consumeConcurrently bool
+	store.Hooks().BeforeSaveAccess.Add(func(ctx context.Context, q Querier, accessData *osin.AccessData) error {
+		if failSave {
+			return errors.New("unavailable")
+		}
+		if consumeConcurrently {
+			_, err := q.ExecContext(ctx, "DELETE FROM device_codes WHERE device_code = ?", deviceCode)
+			return err
+		}
+		return nil
+	})
+	failSave = true
+	tokenForm := url.Values{"grant_type": {DeviceCodeGrantType}, "device_code": {deviceCode}}
+	output = postForm(t, flow.HandleDeviceToken, tokenForm, client.Id, client.Secret)
+	if output["error"] != osin.E_SERVER_ERROR {
+		t.Errorf("\"%v\": expected %v", output["error"], osin.E_SERVER_ERROR)
+	}
+	failSave = false
+	waited()
+	output = postForm(t, flow.HandleDeviceToken, tokenForm, client.Id, client.Secret)
+	if output["access_token"] == nil {
+		t.Fatalf("Unexpected token response %v", output)
+	}
+
+	// A token saved for a device code that a concurrent request consumed is removed
+	output = postForm(t, flow.HandleDeviceAuthorization, url.Values{"scope": {"read"}}, client.Id, client.Secret)
+	deviceCode, _ = output["device_code"].(string)
+	userCode, _ = output["user_code"].(string)
+	if err := store.ApproveDeviceCode(ctx, userCode, "deviceflowuser", nil); err != nil {
+		t.Fatal(err)
+	}
+	consumeConcurrently = true
+	tokenForm.Set("device_code", deviceCode)
+	output = postForm(t, flow.HandleDeviceToken, tokenForm, client.Id, client.Secret)
+	if output["error"] != osin.E_INVALID_GRANT {
+		t.Errorf("\"%v\": expected %v", output["error"], osin.E_INVALID_GRANT)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM access_data").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d tokens, expected only the first one", count)
+	}
+}
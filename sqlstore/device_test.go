@@ -0,0 +1,219 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeviceCode(t *testing.T) {
+	ctx := context.Background()
+	store := testingContext.Store
+
+	dc := &DeviceCode{DeviceCode: "devicecode", UserCode: "BCDF-GHJK", ClientID: "deviceclient",
+		Scope: "read", ExpiresIn: 600, Interval: 5, CreatedAt: time.Now()}
+	if err := store.SaveDeviceCode(ctx, dc); err != nil {
+		t.Fatal(err)
+	}
+	defer store.RemoveDeviceCode(ctx, dc.DeviceCode)
+
+	retDC, err := store.LoadByUserCode(ctx, dc.UserCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retDC.DeviceCode != dc.DeviceCode || retDC.Status != DeviceCodePending || retDC.Interval != dc.Interval {
+		t.Errorf("\"%v\": expected %v", retDC, dc)
+	}
+
+	if _, err := store.PollDeviceCode(ctx, dc.DeviceCode, "otherclient"); err == nil {
+		t.Error("Error should be thrown for another client")
+	}
+	if _, err := store.PollDeviceCode(ctx, dc.DeviceCode, dc.ClientID); err != ErrAuthorizationPending {
+		t.Errorf("\"%v\": expected %v", err, ErrAuthorizationPending)
+	}
+
+	// Polling again right away increases the interval
+	if _, err := store.PollDeviceCode(ctx, dc.DeviceCode, dc.ClientID); err != ErrSlowDown {
+		t.Errorf("\"%v\": expected %v", err, ErrSlowDown)
+	}
+	retDC, _ = store.LoadByUserCode(ctx, dc.UserCode)
+	if expected := dc.Interval + slowDownIncrement; retDC.Interval != expected {
+		t.Errorf("\"%v\": expected %v", retDC.Interval, expected)
+	}
+
+	userData := map[string]interface{}{"Username": "deviceuser"}
+	if err := store.ApproveDeviceCode(ctx, dc.UserCode, "deviceuser", userData); err != nil {
+		t.Fatal(err)
+	}
+	// Only pending requests can be approved or denied
+	if err := store.DenyDeviceCode(ctx, dc.UserCode); err == nil {
+		t.Error("Error should be thrown")
+	}
+
+	pretendWaited(t, dc.DeviceCode)
+	retDC, err = store.PollDeviceCode(ctx, dc.DeviceCode, dc.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retDC.Subject != "deviceuser" || !reflect.DeepEqual(retDC.UserData, userData) {
+		t.Errorf("\"%v\": expected subject deviceuser with %v", retDC, userData)
+	}
+
+	// The approved device code can only be used once
+	if err := store.ConsumeDeviceCode(ctx, dc.DeviceCode); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ConsumeDeviceCode(ctx, dc.DeviceCode); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+	pretendWaited(t, dc.DeviceCode)
+	if _, err := store.PollDeviceCode(ctx, dc.DeviceCode, dc.ClientID); err == nil {
+		t.Error("Error should be thrown")
+	}
+}
+
+func TestDeviceCodeDeniedAndExpired(t *testing.T) {
+	ctx := context.Background()
+	store := testingContext.Store
+
+	denied := &DeviceCode{DeviceCode: "denieddevicecode", UserCode: "LMNP-QRST", ClientID: "deviceclient",
+		ExpiresIn: 600, CreatedAt: time.Now()}
+	expired := &DeviceCode{DeviceCode: "expireddevicecode", UserCode: "VWXZ-BCDF", ClientID: "deviceclient",
+		ExpiresIn: 600, CreatedAt: time.Now().Add(-time.Hour)}
+	for _, dc := range []*DeviceCode{denied, expired} {
+		if err := store.SaveDeviceCode(ctx, dc); err != nil {
+			t.Fatal(err)
+		}
+		defer store.RemoveDeviceCode(ctx, dc.DeviceCode)
+	}
+
+	if err := store.DenyDeviceCode(ctx, denied.UserCode); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PollDeviceCode(ctx, denied.DeviceCode, denied.ClientID); err != ErrAccessDenied {
+		t.Errorf("\"%v\": expected %v", err, ErrAccessDenied)
+	}
+
+	if _, err := store.LoadByUserCode(ctx, expired.UserCode); err != ErrExpiredToken {
+		t.Errorf("\"%v\": expected %v", err, ErrExpiredToken)
+	}
+	if _, err := store.PollDeviceCode(ctx, expired.DeviceCode, expired.ClientID); err != ErrExpiredToken {
+		t.Errorf("\"%v\": expected %v", err, ErrExpiredToken)
+	}
+}
+
+// pretendWaited moves the last poll of a device code into the past so that the
+// next poll does not have to wait for the interval
+func pretendWaited(t *testing.T, deviceCode string) {
+	_, err := testingContext.DB.DB().Exec("UPDATE device_codes SET last_polled_at = ? WHERE device_code = ?",
+		time.Now().Add(-time.Hour).UTC(), deviceCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeviceCodeConcurrentPolls(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db)
+	dc := &DeviceCode{DeviceCode: "concurrentdevicecode", UserCode: "CDFG-HJKL", ClientID: "deviceclient",
+		ExpiresIn: 600, Interval: 5, CreatedAt: time.Now()}
+	if err := store.SaveDeviceCode(ctx, dc); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ApproveDeviceCode(ctx, dc.UserCode, "deviceuser", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only one of the concurrent polls receives the approved request. The others
+	// poll too soon.
+	results := make(chan error, 10)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			_, err := store.PollDeviceCode(ctx, dc.DeviceCode, dc.ClientID)
+			results <- err
+		}()
+	}
+	granted := 0
+	for i := 0; i < cap(results); i++ {
+		if err := <-results; err == nil {
+			granted++
+		} else if err != ErrSlowDown {
+			t.Errorf("\"%v\": expected %v", err, ErrSlowDown)
+		}
+	}
+	if granted != 1 {
+		t.Errorf("%d polls were granted, expected 1", granted)
+	}
+}
+
+func TestDeviceUserCodeNormalization(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db)
+	dc := &DeviceCode{DeviceCode: "normalizeddevicecode", UserCode: "wxyz-bcdf", ClientID: "deviceclient",
+		ExpiresIn: 600, Interval: 5, CreatedAt: time.Now()}
+	if err := store.SaveDeviceCode(ctx, dc); err != nil {
+		t.Fatal(err)
+	}
+
+	// The end user may enter the code in any case, with or without separators
+	for _, userCode := range []string{"WXYZ-BCDF", "wxyzbcdf", "WXYZ BCDF"} {
+		retDC, err := store.LoadByUserCode(ctx, userCode)
+		if err != nil {
+			t.Fatalf("%s: %v", userCode, err)
+		}
+		if retDC.DeviceCode != dc.DeviceCode || retDC.UserCode != "WXYZBCDF" {
+			t.Errorf("%s: \"%v\": expected %v", userCode, retDC, dc)
+		}
+	}
+	if err := store.ApproveDeviceCode(ctx, "wxyzbcdf", "deviceuser", nil); err != nil {
+		t.Error(err)
+	}
+
+	// User codes are unique per tenant
+	duplicate := &DeviceCode{DeviceCode: "duplicatedevicecode", UserCode: "WXYZBCDF", ClientID: "deviceclient",
+		ExpiresIn: 600, CreatedAt: time.Now()}
+	if err := store.SaveDeviceCode(ctx, duplicate); err == nil {
+		t.Error("Error should be thrown for a duplicate user code")
+	}
+	if err := NewSQLStorage(db, WithTenant("other")).SaveDeviceCode(ctx, duplicate); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApproveExpiredDeviceCode(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	now := time.Now()
+	store := NewSQLStorage(db, WithClock(func() time.Time { return now }))
+	dc := &DeviceCode{DeviceCode: "approvedevicecode", UserCode: "MNPQ-RSTV", ClientID: "deviceclient",
+		ExpiresIn: 600, Interval: 5, CreatedAt: now.In(time.FixedZone("UTC-10", -10*3600))}
+	if err := store.SaveDeviceCode(ctx, dc); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(11 * time.Minute)
+	if err := store.ApproveDeviceCode(ctx, dc.UserCode, "deviceuser", nil); err != ErrExpiredToken {
+		t.Errorf("\"%v\": expected %v", err, ErrExpiredToken)
+	}
+	if err := store.DenyDeviceCode(ctx, dc.UserCode); err != ErrExpiredToken {
+		t.Errorf("\"%v\": expected %v", err, ErrExpiredToken)
+	}
+	if err := store.ApproveDeviceCode(ctx, "missing", "deviceuser", nil); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+
+	now = now.Add(-2 * time.Minute)
+	if err := store.ApproveDeviceCode(ctx, dc.UserCode, "deviceuser", nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,175 @@
+package sqlstore
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dialect is the SQL flavor spoken by the database behind a SQLStorage.
+// It controls placeholder syntax, column types in the migration DDL
+// and how JSON user data is queried.
+type Dialect int
+
+const (
+	// SQLite is the default dialect. JSON user data is stored as TEXT and
+	// queried with json_extract.
+	SQLite Dialect = iota
+	// Postgres uses $n placeholders and stores JSON user data as jsonb.
+	Postgres
+	// MySQL stores JSON user data in a native JSON column. Time columns
+	// require parseTime=true in the DSN.
+	MySQL
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case SQLite:
+		return "sqlite"
+	case Postgres:
+		return "postgres"
+	case MySQL:
+		return "mysql"
+	}
+	return "Dialect(" + strconv.Itoa(int(d)) + ")"
+}
+
+// rebind rewrites the ? placeholders in query into the placeholder syntax of the dialect
+func (d Dialect) rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// columnType is a dialect independent column type used by the migration DDL
+type columnType int
+
+const (
+	// keyColumn is a string column that is part of a key or index
+	keyColumn columnType = iota
+	textColumn
+	intColumn
+	timeColumn
+	// jsonColumn holds json encoded user data
+	jsonColumn
+	// tenantColumn is the tenant_id key column, which defaults to the empty tenant
+	tenantColumn
+)
+
+// sqlType returns the column type for t. JSON columns only use the native json
+// type of the dialect if nativeJSON is set.
+func (d Dialect) sqlType(t columnType, nativeJSON bool) string {
+	switch t {
+	case keyColumn:
+		return "VARCHAR(255)"
+	case tenantColumn:
+		return "VARCHAR(255) NOT NULL DEFAULT ''"
+	case intColumn:
+		return "INTEGER"
+	case timeColumn:
+		switch d {
+		case Postgres:
+			return "TIMESTAMP WITH TIME ZONE"
+		case MySQL:
+			return "DATETIME(6)"
+		}
+		return "DATETIME"
+	case jsonColumn:
+		if nativeJSON {
+			switch d {
+			case Postgres:
+				return "JSONB"
+			case MySQL:
+				return "JSON"
+			}
+		}
+	}
+	return "TEXT"
+}
+
+// addSeconds returns a SQL expression adding the seconds of an integer column to
+// a time column. The SQLite result is formatted like the UTC times bound by the
+// driver, so that it compares correctly as text.
+func (d Dialect) addSeconds(timeColumn, secondsColumn string) string {
+	switch d {
+	case Postgres:
+		return timeColumn + " + " + secondsColumn + " * INTERVAL '1 second'"
+	case MySQL:
+		return "TIMESTAMPADD(SECOND, " + secondsColumn + ", " + timeColumn + ")"
+	}
+	return "strftime('%Y-%m-%d %H:%M:%f+00:00', " + timeColumn + ", " + secondsColumn + " || ' seconds')"
+}
+
+// isUndefinedTable reports whether err is the error of the dialect for a query on
+// a table that does not exist
+func (d Dialect) isUndefinedTable(err error) bool {
+	msg := err.Error()
+	switch d {
+	case Postgres:
+		return strings.Contains(msg, "42P01") || strings.Contains(msg, "does not exist")
+	case MySQL:
+		return strings.Contains(msg, "1146") || strings.Contains(msg, "doesn't exist")
+	}
+	return strings.Contains(msg, "no such table")
+}
+
+// jsonPathRegexp matches the supported subset of json paths: $ followed by
+// object keys (.key) and array indexes ([n])
+var jsonPathRegexp = regexp.MustCompile(`^\$(\.[A-Za-z_][A-Za-z0-9_]*|\[[0-9]+\])*$`)
+
+// jsonPathSegments splits a json path like $.roles[0] into its keys and indexes
+func jsonPathSegments(jsonPath string) ([]string, error) {
+	if !jsonPathRegexp.MatchString(jsonPath) {
+		return nil, fmt.Errorf("sqlstore: unsupported json path %q", jsonPath)
+	}
+
+	return strings.FieldsFunc(jsonPath[1:], func(r rune) bool {
+		return r == '.' || r == '[' || r == ']'
+	}), nil
+}
+
+// jsonMatch returns a boolean SQL expression comparing the value at a json path
+// of column with a json encoded value. The expression takes two arguments: the
+// path returned by jsonPathArg and the json encoded value.
+func (d Dialect) jsonMatch(column string, nativeJSON bool) string {
+	// Empty strings mean no user data and are not valid json
+	if !nativeJSON {
+		column = "NULLIF(" + column + ", '')"
+	}
+
+	switch d {
+	case Postgres:
+		if !nativeJSON {
+			column = "CAST(" + column + " AS jsonb)"
+		}
+		return column + " #> ? = CAST(? AS jsonb)"
+	case MySQL:
+		return "JSON_EXTRACT(" + column + ", ?) = CAST(? AS JSON)"
+	}
+	return "json_extract(" + column + ", ?) = json_extract(?, '$')"
+}
+
+// jsonPathArg converts a validated json path into the path argument of jsonMatch
+func (d Dialect) jsonPathArg(jsonPath string) (string, error) {
+	segments, err := jsonPathSegments(jsonPath)
+	if err != nil {
+		return "", err
+	}
+	if d == Postgres {
+		return "{" + strings.Join(segments, ",") + "}", nil
+	}
+	return jsonPath, nil
+}
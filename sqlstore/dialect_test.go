@@ -0,0 +1,44 @@
+package sqlstore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	query := "SELECT * FROM clients WHERE id = ? AND secret = ?"
+	if ret := SQLite.rebind(query); ret != query {
+		t.Errorf("\"%v\": expected %v", ret, query)
+	}
+	if ret := MySQL.rebind(query); ret != query {
+		t.Errorf("\"%v\": expected %v", ret, query)
+	}
+	expected := "SELECT * FROM clients WHERE id = $1 AND secret = $2"
+	if ret := Postgres.rebind(query); ret != expected {
+		t.Errorf("\"%v\": expected %v", ret, expected)
+	}
+}
+
+func TestJSONPathArg(t *testing.T) {
+	segments, err := jsonPathSegments("$.Roles[0].Name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"Roles", "0", "Name"}; !reflect.DeepEqual(segments, expected) {
+		t.Errorf("\"%v\": expected %v", segments, expected)
+	}
+
+	arg, err := Postgres.jsonPathArg("$.Roles[0].Name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "{Roles,0,Name}"; arg != expected {
+		t.Errorf("\"%v\": expected %v", arg, expected)
+	}
+
+	for _, path := range []string{"", "Username", "$.User name", "$.a'); DROP TABLE clients; --"} {
+		if _, err := SQLite.jsonPathArg(path); err == nil {
+			t.Errorf("Error should be thrown for json path %q", path)
+		}
+	}
+}
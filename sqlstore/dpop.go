@@ -0,0 +1,92 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrDPoPReplay is returned by RecordDPoPProof if a DPoP proof with the same jti
+// was already used
+var ErrDPoPReplay = errors.New("sqlstore: DPoP proof replayed")
+
+// Confirmation is the confirmation (cnf) claim binding an access token to a key
+// held by the client. It is stored as json in the cnf column of access_data.
+type Confirmation struct {
+	// JKT is the JWK SHA-256 thumbprint of a DPoP key (RFC 9449)
+	JKT string `json:"jkt,omitempty"`
+	// X5TS256 is the SHA-256 thumbprint of a mutual TLS client certificate (RFC 8705)
+	X5TS256 string `json:"x5t#S256,omitempty"`
+}
+
+// confirmationArg returns the query argument for the cnf column
+func confirmationArg(cnf *Confirmation) (interface{}, error) {
+	if cnf == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(cnf)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// getConfirmation unmarshals a scanned cnf column
+func getConfirmation(cnf string) (*Confirmation, error) {
+	if cnf == "" {
+		return nil, nil
+	}
+	var data Confirmation
+	err := json.Unmarshal([]byte(cnf), &data)
+	return &data, err
+}
+
+// RecordDPoPProof records the jti of a DPoP proof for ttl, which should cover the
+// window in which the proof is accepted. It returns ErrDPoPReplay if the jti was
+// already recorded and has not expired yet.
+func (store *SQLStorage) RecordDPoPProof(ctx context.Context, jti string, ttl time.Duration) (err error) {
+	ctx, op := store.begin(ctx, "RecordDPoPProof")
+	defer op.end(&err)
+	now := store.now()
+
+	// Expired entries may be reused
+	_, err = store.exec(ctx, store.authDB, store.rebind(
+		"DELETE FROM dpop_jti WHERE tenant_id = ? AND jti = ? AND expires_at <= ?"), store.tenantID, jti, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.exec(ctx, store.authDB, store.rebind(
+		"INSERT INTO dpop_jti(tenant_id, jti, expires_at) VALUES(?, ?, ?)"), store.tenantID, jti, now.Add(ttl))
+	if err == nil {
+		return nil
+	}
+
+	// Tell a primary key violation apart from other errors without relying on driver errors
+	var existing string
+	row := store.queryRow(ctx, store.authDB, store.rebind(
+		"SELECT jti FROM dpop_jti WHERE tenant_id = ? AND jti = ?"), store.tenantID, jti)
+	if row.Scan(&existing) == nil {
+		return ErrDPoPReplay
+	}
+	return err
+}
+
+// PurgeDPoPProofs removes the expired entries of the DPoP replay cache of the tenant
+// and returns the number of removed entries
+func (store *SQLStorage) PurgeDPoPProofs(ctx context.Context) (_ int64, err error) {
+	ctx, op := store.begin(ctx, "PurgeDPoPProofs")
+	defer op.end(&err)
+	result, err := store.exec(ctx, store.authDB, store.rebind(
+		"DELETE FROM dpop_jti WHERE tenant_id = ? AND expires_at <= ?"), store.tenantID, store.now())
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	store.purged("dpop_jti", rows)
+	return rows, nil
+}
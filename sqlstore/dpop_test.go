@@ -0,0 +1,82 @@
+package sqlstore
+
+import (
+	"context"
+	"github.com/RangelReale/osin"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfirmation(t *testing.T) {
+	client := &osin.DefaultClient{Id: "dpopclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := testingContext.Store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveClient(client.Id)
+
+	cnf := &Confirmation{JKT: "0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I"}
+	accessData := &osin.AccessData{AccessToken: "dpopaccess", RefreshToken: "dpoprefresh", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client,
+		UserData: &ExtendedUserData{UserData: userData[0], Confirmation: cnf}}
+	if err := testingContext.Store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveAccess(accessData.AccessToken)
+
+	for _, load := range []func() (*osin.AccessData, error){
+		func() (*osin.AccessData, error) { return testingContext.Store.LoadAccess(accessData.AccessToken) },
+		func() (*osin.AccessData, error) { return testingContext.Store.LoadRefresh(accessData.RefreshToken) },
+	} {
+		retAccessData, err := load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ext, ok := retAccessData.UserData.(*ExtendedUserData)
+		if !ok {
+			t.Fatalf("User data has type %T", retAccessData.UserData)
+		}
+		if !reflect.DeepEqual(ext.Confirmation, cnf) {
+			t.Errorf("\"%v\": expected %v", ext.Confirmation, cnf)
+		}
+		if !reflect.DeepEqual(ext.UserData, userData[0]) {
+			t.Errorf("\"%v\": expected %v", ext.UserData, userData[0])
+		}
+	}
+}
+
+func TestDPoPReplay(t *testing.T) {
+	ctx := context.Background()
+	store := testingContext.Store
+	// The unexpired jti would be a replay in the next run of the test
+	t.Cleanup(func() {
+		if _, err := testingContext.DB.DB().Exec("DELETE FROM dpop_jti WHERE jti = ?", "e1j3V_bKic8-LAEB"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if err := store.RecordDPoPProof(ctx, "e1j3V_bKic8-LAEB", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RecordDPoPProof(ctx, "e1j3V_bKic8-LAEB", time.Minute); err != ErrDPoPReplay {
+		t.Errorf("\"%v\": expected %v", err, ErrDPoPReplay)
+	}
+
+	// Expired proofs are purged and can be recorded again
+	if err := store.RecordDPoPProof(ctx, "expiredjti", -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RecordDPoPProof(ctx, "expiredjti", -time.Minute); err != nil {
+		t.Errorf("Expired jti should be reusable: %v", err)
+	}
+	purged, err := store.PurgeDPoPProofs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("\"%v\": expected 1", purged)
+	}
+	if err := store.RecordDPoPProof(ctx, "e1j3V_bKic8-LAEB", time.Minute); err != ErrDPoPReplay {
+		t.Errorf("Unexpired jti should not be purged: %v", err)
+	}
+}
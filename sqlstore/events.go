@@ -0,0 +1,84 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+)
+
+// recordEvent records a lifecycle event in the transaction of a write, in the audit
+// trail if WithAuditEvents is used and in the outbox if WithOutbox is used. token
+// may be empty.
+func (store *SQLStorage) recordEvent(ctx context.Context, tx Querier, eventType AuditEventType, clientID, subject,
+	token string) error {
+	if !store.auditEvents && !store.outbox {
+		return nil
+	}
+
+	id, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+	event := &AuditEvent{ID: id, Type: eventType, ClientID: clientID, Subject: subject, CreatedAt: store.now()}
+	if token != "" {
+		event.TokenFingerprint = TokenFingerprint(token)
+	}
+
+	if store.auditEvents {
+		if err := store.insertAuditEvent(ctx, tx, event); err != nil {
+			return err
+		}
+	}
+	if store.outbox {
+		if err := store.insertOutboxEvent(ctx, tx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRemoval records the removal of the row of table whose column is token, if
+// the row exists and is not revoked. It must be called before the row is removed.
+func (store *SQLStorage) recordRemoval(ctx context.Context, tx Querier, eventType AuditEventType, table, column,
+	token string) error {
+	if !store.auditEvents && !store.outbox {
+		return nil
+	}
+
+	var (
+		clientID string
+		subject  sql.NullString
+	)
+	row := store.queryRow(ctx, tx, store.rebind(
+		"SELECT client_id, subject FROM "+table+" WHERE tenant_id = ? AND "+column+" = ?"+notRevoked(table)),
+		store.tenantID, token)
+	if err := row.Scan(&clientID, &subject); err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return store.recordEvent(ctx, tx, eventType, clientID, subject.String, token)
+}
+
+// scanEvents scans and closes rows of the columns id, event_type, client_id,
+// subject, token_fingerprint and created_at
+func scanEvents(rows *sql.Rows) ([]*AuditEvent, error) {
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		var (
+			event       AuditEvent
+			eventType   string
+			subject     sql.NullString
+			fingerprint sql.NullString
+		)
+		if err := rows.Scan(&event.ID, &eventType, &event.ClientID, &subject, &fingerprint, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.Type = AuditEventType(eventType)
+		event.Subject = subject.String
+		event.TokenFingerprint = fingerprint.String
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
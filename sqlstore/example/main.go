@@ -13,7 +13,10 @@ import (
 	"github.com/RangelReale/osincli"
 	"github.com/jinzhu/gorm"
 	_ "github.com/mattn/go-sqlite3"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
 )
 
 func main() {
@@ -30,11 +33,16 @@ func main() {
 	}
 	defer db.Close()
 
-	db.AutoMigrate(&gorm_schema.Client{}, &gorm_schema.AuthorizeData{}, &gorm_schema.AccessData{})
+	db.AutoMigrate(&gorm_schema.Client{}, &gorm_schema.AuthorizeData{}, &gorm_schema.AccessData{},
+		&gorm_schema.Consent{}, &gorm_schema.DeviceCode{}, &gorm_schema.PushedRequest{},
+		&gorm_schema.DPoPJTI{}, &gorm_schema.AuditEvent{},
+		&gorm_schema.OutboxEvent{})
 	db.Model(&gorm_schema.AccessData{}).AddForeignKey("authorize_data_code", "authorize_data", "CASCADE", "RESTRICT")
 	db.Model(&gorm_schema.AccessData{}).AddForeignKey("prev_access_data_token", "access_data", "CASCADE", "RESTRICT")
 
-	sstorage := sqlstore.NewSQLStorage(db.DB())
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	sstorage := sqlstore.NewSQLStorage(db.DB(), sqlstore.WithLogger(logger),
+		sqlstore.WithSlowQueryThreshold(100*time.Millisecond))
 
 	sstorage.SetClient(&osin.DefaultClient{
 		Id:          "1234",
@@ -74,7 +82,7 @@ func main() {
 			server.FinishAuthorizeRequest(resp, r, ar)
 		}
 		if resp.IsError && resp.InternalError != nil {
-			fmt.Printf("ERROR: %s\n", resp.InternalError)
+			logger.Error("internal error", "error", resp.InternalError)
 		}
 		osin.OutputJSON(resp, w, r)
 	})
@@ -89,7 +97,7 @@ func main() {
 			server.FinishAccessRequest(resp, r, ar)
 		}
 		if resp.IsError && resp.InternalError != nil {
-			fmt.Printf("ERROR: %s\n", resp.InternalError)
+			logger.Error("internal error", "error", resp.InternalError)
 		}
 		osin.OutputJSON(resp, w, r)
 	})
@@ -0,0 +1,48 @@
+package sqlstore
+
+import (
+	"context"
+	"time"
+)
+
+// WithClock sets the function that returns the current time, which defaults to
+// time.Now. It is used for expiry, revocation and event timestamps, so tests can
+// control them.
+func WithClock(now func() time.Time) Option {
+	return func(store *SQLStorage) {
+		store.clock = now
+	}
+}
+
+// now returns the current time of the clock in UTC. Stored and compared times
+// are UTC, because SQLite compares them as text.
+func (store *SQLStorage) now() time.Time {
+	return store.clock().UTC()
+}
+
+// WithExpiryEnforcement makes LoadAuthorize and LoadAccess return sql.ErrNoRows for
+// expired codes and tokens. The expiry is checked in the query using the indexed
+// expires_at column, which is computed when the data is saved. The authorize data
+// and previous access data referenced by access data are loaded even if expired.
+func WithExpiryEnforcement() Option {
+	return func(store *SQLStorage) {
+		store.enforceExpiry = true
+	}
+}
+
+// unexpired returns the condition and argument that exclude expired rows if live
+// is set and expiry is enforced
+func (store *SQLStorage) unexpired(live bool) (string, []interface{}) {
+	if !live || !store.enforceExpiry {
+		return "", nil
+	}
+	return " AND expires_at > ?", []interface{}{store.now()}
+}
+
+// backfillExpiresAt sets expires_at of the rows of table saved before the column
+// existed. It is a single statement, so a failed migration can simply be rerun.
+func (store *SQLStorage) backfillExpiresAt(ctx context.Context, table string) error {
+	_, err := store.exec(ctx, store.authDB, store.rebind("UPDATE "+table+" SET expires_at = "+
+		store.dialect.addSeconds("created_at", "expires_in")+" WHERE expires_at IS NULL"))
+	return err
+}
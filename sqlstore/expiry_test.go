@@ -0,0 +1,123 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/RangelReale/osin"
+	"testing"
+	"time"
+)
+
+func TestExpiryEnforcement(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewSQLStorage(db, WithExpiryEnforcement(), WithClock(func() time.Time { return now }))
+	client := &osin.DefaultClient{Id: "expiryclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	authData := &osin.AuthorizeData{Code: "expirycode", ExpiresIn: 60, RedirectUri: "redirect",
+		CreatedAt: now, Client: client}
+	if err := store.SaveAuthorize(authData); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "expiryaccess", RefreshToken: "expiryrefresh", ExpiresIn: 3600,
+		CreatedAt: now, Client: client, AuthorizeData: authData}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+
+	// The code expires before the token, which still references it
+	now = now.Add(time.Minute)
+	if _, err := store.LoadAuthorize(authData.Code); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+	loaded, err := store.LoadAccess(accessData.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.AuthorizeData == nil || loaded.AuthorizeData.Code != authData.Code {
+		t.Errorf("\"%v\": expected the expired authorize data", loaded.AuthorizeData)
+	}
+
+	// Refresh tokens outlive their access tokens
+	now = now.Add(time.Hour)
+	if _, err := store.LoadAccess(accessData.AccessToken); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+	if _, err := store.LoadRefresh(accessData.RefreshToken); err != nil {
+		t.Error(err)
+	}
+
+	// Without enforcement expired rows are loaded
+	if _, err := NewSQLStorage(db).LoadAccess(accessData.AccessToken); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExpiresAtBackfill(t *testing.T) {
+	ctx := context.Background()
+	db := openMemoryDB(t)
+	defer db.Close()
+
+	// Create the schema as it was before expires_at was added
+	store := NewSQLStorage(db)
+	for _, m := range migrations {
+		if m.version >= 15 {
+			break
+		}
+		if err := m.up(ctx, store); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createdAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.FixedZone("UTC-10", -10*3600))
+	if _, err := db.Exec(`INSERT INTO access_data(tenant_id, access_token, refresh_token, expires_in, scope,
+		redirect_uri, created_at, authorize_data_code, prev_access_data_token, client_id)
+		VALUES('', 'legacyaccess', '', 60, '', '', ?, '', '', 'legacy')`, createdAt); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var expiresAt time.Time
+	row := db.QueryRow("SELECT expires_at FROM access_data WHERE access_token = 'legacyaccess'")
+	if err := row.Scan(&expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if expected := createdAt.Add(time.Minute); !expiresAt.Equal(expected) {
+		t.Errorf("\"%v\": expected %v", expiresAt, expected)
+	}
+}
+
+func TestExpiryEnforcementTimeZones(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	// The clock and the saved data use different offsets, which do not compare as
+	// text in SQLite
+	east := time.FixedZone("UTC+14", 14*3600)
+	west := time.FixedZone("UTC-10", -10*3600)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, east)
+	store := NewSQLStorage(db, WithExpiryEnforcement(), WithClock(func() time.Time { return now }))
+	client := &osin.DefaultClient{Id: "zoneclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "zoneaccess", ExpiresIn: 3600, CreatedAt: now.In(west), Client: client}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(30 * time.Minute)
+	if _, err := store.LoadAccess(accessData.AccessToken); err != nil {
+		t.Errorf("\"%v\": expected the token to be valid", err)
+	}
+	now = now.Add(time.Hour)
+	if _, err := store.LoadAccess(accessData.AccessToken); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+}
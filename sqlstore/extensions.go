@@ -0,0 +1,57 @@
+package sqlstore
+
+// ExtendedUserData can be used as the UserData of osin.AuthorizeData and
+// osin.AccessData to save extension values that are stored in their own
+// typed columns instead of the user_data column. UserData is the only field
+// osin carries between the authorize and token endpoints, so this is how
+// values set while handling an authorize request reach SaveAuthorize.
+//
+// Only UserData is stored in the user_data column. The Load methods return
+// an *ExtendedUserData if any of the extension columns of a row are set, and
+// the plain user data otherwise.
+type ExtendedUserData struct {
+	UserData interface{}
+
+	// Subject identifies the end user the data was issued to
+	Subject string
+
+	// Audience lists the resources the data is restricted to
+	Audience []string
+
+	// AuthorizationDetails are the fine-grained permissions of RFC 9396
+	AuthorizationDetails []AuthorizationDetail
+
+	// OpenID Connect values of the authorization request (authorize_data only)
+	OIDC *OIDCData
+
+	// Confirmation binds the access token to a DPoP key or mTLS certificate
+	// (access_data only)
+	Confirmation *Confirmation
+
+	// TokenExchange is set for access tokens issued by a token exchange
+	// (access_data only)
+	TokenExchange *TokenExchange
+}
+
+// empty reports whether none of the extension values are set
+func (ext *ExtendedUserData) empty() bool {
+	return ext.Subject == "" && len(ext.Audience) == 0 && len(ext.AuthorizationDetails) == 0 &&
+		ext.OIDC == nil && ext.Confirmation == nil && ext.TokenExchange == nil
+}
+
+// splitUserData separates plain user data from the extension values
+func splitUserData(userData interface{}) (interface{}, *ExtendedUserData) {
+	if ext, ok := userData.(*ExtendedUserData); ok && ext != nil {
+		return ext.UserData, ext
+	}
+	return userData, &ExtendedUserData{}
+}
+
+// joinUserData wraps user data with the loaded extension values if there are any
+func joinUserData(userData interface{}, ext *ExtendedUserData) interface{} {
+	if ext.empty() {
+		return userData
+	}
+	ext.UserData = userData
+	return ext
+}
@@ -7,15 +7,24 @@ import "time"
  * that match the tables needed for SQLStorage
  */
 
+// TableNameFunc returns the table name of a model by its default table name. Set it
+// to the TableName method of the sqlstore.Naming of the storage to use the same
+// table names. Custom column names are not applied to the models.
+var TableNameFunc = func(table string) string {
+	return table
+}
+
 type Client struct {
 	ID          string `gorm:"primary_key"`
 	Secret      string
 	RedirectUri string
 	UserData    string
+
+	TenantID string `gorm:"primary_key"`
 }
 
 func (c Client) TableName() string {
-	return "clients"
+	return TableNameFunc("clients")
 }
 
 type AuthorizeData struct {
@@ -27,10 +36,29 @@ type AuthorizeData struct {
 	CreatedAt   time.Time
 	UserData    string
 	ClientID    string `sql:"index"`
+
+	CodeChallenge       string
+	CodeChallengeMethod string
+
+	Nonce    *string
+	AuthTime *time.Time
+	Acr      *string
+	Amr      *string
+	Claims   *string
+
+	Subject              *string `sql:"index"`
+	Audience             *string
+	AuthorizationDetails *string
+
+	ExpiresAt     *time.Time `sql:"index"`
+	RevokedAt     *time.Time `sql:"index"`
+	RevokedReason *string
+
+	TenantID string `gorm:"primary_key"`
 }
 
 func (a AuthorizeData) TableName() string {
-	return "authorize_data"
+	return TableNameFunc("authorize_data")
 }
 
 type AccessData struct {
@@ -44,8 +72,111 @@ type AccessData struct {
 	AuthorizeDataCode   string `sql:"index"`
 	PrevAccessDataToken string `sql:"index"`
 	ClientID            string `sql:"index"`
+
+	Subject              *string `sql:"index"`
+	Cnf                  *string
+	Audience             *string
+	AuthorizationDetails *string
+	SubjectToken         *string `sql:"index"`
+	ActorToken           *string `sql:"index"`
+	IssuedTokenType      *string
+
+	ExpiresAt     *time.Time `sql:"index"`
+	RevokedAt     *time.Time `sql:"index"`
+	RevokedReason *string
+
+	TenantID string `gorm:"primary_key"`
 }
 
 func (a AccessData) TableName() string {
-	return "access_data"
+	return TableNameFunc("access_data")
+}
+
+type Consent struct {
+	Subject   string `gorm:"primary_key"`
+	ClientID  string `gorm:"primary_key"`
+	Scope     string
+	GrantedAt time.Time
+	ExpiresAt *time.Time
+
+	TenantID string `gorm:"primary_key"`
+}
+
+func (c Consent) TableName() string {
+	return TableNameFunc("consents")
+}
+
+type DeviceCode struct {
+	DeviceCode   string `gorm:"primary_key"`
+	UserCode     string `gorm:"unique_index:uidx_device_codes_tenant_id_user_code"`
+	ClientID     string
+	Scope        string
+	ExpiresIn    int32
+	PollInterval int32
+	CreatedAt    time.Time
+	Status       string
+	Subject      *string
+	UserData     *string
+	LastPolledAt *time.Time
+
+	TenantID string `gorm:"primary_key;unique_index:uidx_device_codes_tenant_id_user_code"`
+}
+
+func (d DeviceCode) TableName() string {
+	return TableNameFunc("device_codes")
+}
+
+type PushedRequest struct {
+	RequestUri string `gorm:"primary_key"`
+	ClientID   string
+	Parameters string
+	ExpiresIn  int32
+	CreatedAt  time.Time
+
+	TenantID string `gorm:"primary_key"`
+}
+
+func (p PushedRequest) TableName() string {
+	return TableNameFunc("par_requests")
+}
+
+type DPoPJTI struct {
+	JTI       string    `gorm:"column:jti;primary_key"`
+	ExpiresAt time.Time `sql:"index"`
+
+	TenantID string `gorm:"primary_key"`
+}
+
+func (d DPoPJTI) TableName() string {
+	return TableNameFunc("dpop_jti")
+}
+
+type AuditEvent struct {
+	ID               string `gorm:"primary_key"`
+	EventType        string
+	ClientID         string `sql:"index"`
+	Subject          string `sql:"index"`
+	TokenFingerprint string
+	CreatedAt        time.Time `sql:"index"`
+
+	TenantID string `gorm:"primary_key"`
+}
+
+func (a AuditEvent) TableName() string {
+	return TableNameFunc("audit_events")
+}
+
+type OutboxEvent struct {
+	ID               string `gorm:"primary_key"`
+	EventType        string
+	ClientID         string
+	Subject          string
+	TokenFingerprint string
+	CreatedAt        time.Time `sql:"index"`
+
+	TenantID string `gorm:"primary_key"`
+}
+
+func (o OutboxEvent) TableName() string {
+	return TableNameFunc("outbox_events")
 }
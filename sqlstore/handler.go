@@ -0,0 +1,54 @@
+package sqlstore
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"github.com/RangelReale/osin"
+	"net/http"
+)
+
+// authenticateClient authenticates the client of a request with HTTP basic auth or the
+// client_id and client_secret form parameters, and loads it with GetClient. It sets an
+// error on resp and returns nil if the client can not be authenticated.
+func authenticateClient(resp *osin.Response, r *http.Request) osin.Client {
+	auth, err := osin.CheckBasicAuth(r)
+	if err != nil {
+		resp.SetError(osin.E_INVALID_CLIENT, "")
+		resp.InternalError = err
+		return nil
+	}
+	if auth == nil {
+		auth = &osin.BasicAuth{Username: r.FormValue("client_id"), Password: r.FormValue("client_secret")}
+	}
+	if auth.Username == "" {
+		resp.SetError(osin.E_INVALID_CLIENT, "")
+		return nil
+	}
+
+	client, err := resp.Storage.GetClient(auth.Username)
+	if err == sql.ErrNoRows || err == osin.ErrNotFound {
+		resp.SetError(osin.E_INVALID_CLIENT, "")
+		return nil
+	}
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return nil
+	}
+
+	if !osin.CheckClientSecret(client, auth.Password) {
+		resp.SetError(osin.E_INVALID_CLIENT, "")
+		return nil
+	}
+	return client
+}
+
+// randomToken returns a random url safe token with n bytes of entropy
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
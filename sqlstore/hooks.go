@@ -0,0 +1,84 @@
+package sqlstore
+
+import (
+	"context"
+	"github.com/RangelReale/osin"
+	"sync"
+)
+
+// Hook is called by a storage method with the data it saves, loads or removes.
+// Removals and before load hooks pass the id, code or token that is removed or
+// loaded. Hooks may modify the data,
+// and returning an error vetoes the method, which returns the error. Hooks of
+// saves and removals run in the transaction of the write, which is rolled back if
+// a hook fails, and receive it as q. Hooks of loads receive the database the data
+// is loaded from.
+type Hook[T any] func(ctx context.Context, q Querier, data T) error
+
+// HookList is a list of hooks of one kind, which run in the order they were added
+type HookList[T any] struct {
+	mu    sync.RWMutex
+	hooks []Hook[T]
+}
+
+// Add adds a hook to the end of the list
+func (list *HookList[T]) Add(hook Hook[T]) {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	list.hooks = append(list.hooks, hook)
+}
+
+// run runs the hooks until one of them fails
+func (list *HookList[T]) run(ctx context.Context, q Querier, data T) error {
+	list.mu.RLock()
+	hooks := list.hooks
+	list.mu.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(ctx, q, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hooks are the hooks of the osin storage methods of a SQLStorage. Before hooks run
+// before the row is written, loaded or removed, after hooks after it.
+//
+// The client hooks run in a transaction of the client registry, except for
+// BeforeRemoveClient, which runs in the transaction that removes the codes and
+// tokens of the client. LoadRefresh runs BeforeLoadRefresh with the refresh token
+// and AfterLoadAccess. The load hooks also run for the client and authorize data
+// loaded with access data. With a CachedStorage, load hooks only run when the data
+// is loaded from the database.
+type Hooks struct {
+	BeforeSaveClient   HookList[osin.Client]
+	AfterSaveClient    HookList[osin.Client]
+	BeforeLoadClient   HookList[string]
+	AfterLoadClient    HookList[osin.Client]
+	BeforeRemoveClient HookList[string]
+	AfterRemoveClient  HookList[string]
+
+	BeforeSaveAuthorize   HookList[*osin.AuthorizeData]
+	AfterSaveAuthorize    HookList[*osin.AuthorizeData]
+	BeforeLoadAuthorize   HookList[string]
+	AfterLoadAuthorize    HookList[*osin.AuthorizeData]
+	BeforeRemoveAuthorize HookList[string]
+	AfterRemoveAuthorize  HookList[string]
+
+	BeforeSaveAccess    HookList[*osin.AccessData]
+	AfterSaveAccess     HookList[*osin.AccessData]
+	BeforeLoadAccess    HookList[string]
+	BeforeLoadRefresh   HookList[string]
+	AfterLoadAccess     HookList[*osin.AccessData]
+	BeforeRemoveAccess  HookList[string]
+	AfterRemoveAccess   HookList[string]
+	BeforeRemoveRefresh HookList[string]
+	AfterRemoveRefresh  HookList[string]
+}
+
+// Hooks returns the hook registry of the storage. Hooks can be added at any time and
+// are shared with the storages that wrap it, such as a CachedStorage.
+func (store *SQLStorage) Hooks() *Hooks {
+	return store.hooks
+}
@@ -0,0 +1,284 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/RangelReale/osin"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db)
+	hooks := store.Hooks()
+	errDenied := errors.New("admin scope denied")
+
+	// Deny a scope for a client and stamp extra user data
+	hooks.BeforeSaveAccess.Add(func(ctx context.Context, q Querier, accessData *osin.AccessData) error {
+		if accessData.Client.GetId() == "restricted" && strings.Contains(accessData.Scope, "admin") {
+			return errDenied
+		}
+		accessData.UserData = map[string]interface{}{"stamped": true}
+		return nil
+	})
+	hooks.AfterLoadAccess.Add(func(ctx context.Context, q Querier, accessData *osin.AccessData) error {
+		accessData.Scope += " loaded"
+		return nil
+	})
+	hooks.AfterLoadClient.Add(func(ctx context.Context, q Querier, client osin.Client) error {
+		client.(*osin.DefaultClient).RedirectUri = strings.ToUpper(client.GetRedirectUri())
+		return nil
+	})
+
+	restricted := &osin.DefaultClient{Id: "restricted", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(restricted); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "hookaccess", ExpiresIn: 100, Scope: "read admin",
+		CreatedAt: time.Now(), Client: restricted}
+	if err := store.SaveAccess(accessData); err != errDenied {
+		t.Fatalf("\"%v\": expected %v", err, errDenied)
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); err != sql.ErrNoRows {
+		t.Fatalf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+
+	accessData.Scope = "read"
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.LoadAccess(accessData.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Scope != "read loaded" || loaded.Client.GetRedirectUri() != "REDIRECT" {
+		t.Errorf("Unexpected access data %+v", loaded)
+	}
+	if userData, ok := loaded.UserData.(map[string]interface{}); !ok || userData["stamped"] != true {
+		t.Errorf("\"%v\": expected stamped user data", loaded.UserData)
+	}
+
+	// Hooks of writes run in the transaction of the write, which a veto rolls back
+	hooks.AfterSaveAuthorize.Add(func(ctx context.Context, q Querier, authorizeData *osin.AuthorizeData) error {
+		var count int
+		row := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM authorize_data WHERE code = ?", authorizeData.Code)
+		if err := row.Scan(&count); err != nil {
+			return err
+		}
+		if count != 1 {
+			t.Errorf("%d rows, expected the saved row in the transaction", count)
+		}
+		return errDenied
+	})
+	authorizeData := &osin.AuthorizeData{Code: "hookcode", ExpiresIn: 100, CreatedAt: time.Now(), Client: restricted}
+	if err := store.SaveAuthorize(authorizeData); err != errDenied {
+		t.Fatalf("\"%v\": expected %v", err, errDenied)
+	}
+	if _, err := store.LoadAuthorize(authorizeData.Code); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+
+	// A vetoed removal keeps the client and its tokens
+	removed := []string{}
+	hooks.BeforeRemoveClient.Add(func(ctx context.Context, q Querier, id string) error {
+		if id == "restricted" {
+			return errDenied
+		}
+		return nil
+	})
+	hooks.AfterRemoveAccess.Add(func(ctx context.Context, q Querier, token string) error {
+		removed = append(removed, token)
+		return nil
+	})
+	if err := store.RemoveClient(restricted.Id); err != errDenied {
+		t.Fatalf("\"%v\": expected %v", err, errDenied)
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); err != nil {
+		t.Errorf("\"%v\": expected the access data to be kept", err)
+	}
+	if err := store.RemoveAccess(accessData.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != accessData.AccessToken {
+		t.Errorf("\"%v\": expected %v", removed, accessData.AccessToken)
+	}
+}
+
+func TestBulkRevocationHooks(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db)
+	before, after := []string{}, []string{}
+	store.Hooks().BeforeRemoveAccess.Add(func(ctx context.Context, q Querier, token string) error {
+		before = append(before, token)
+		return nil
+	})
+	store.Hooks().AfterRemoveAccess.Add(func(ctx context.Context, q Querier, token string) error {
+		after = append(after, token)
+		return nil
+	})
+
+	client := &osin.DefaultClient{Id: "bulkhookclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	for _, accessData := range []*osin.AccessData{
+		{AccessToken: "consenthook", UserData: &ExtendedUserData{Subject: "alice"}},
+		{AccessToken: "subjecthook", UserData: &ExtendedUserData{Subject: "bob"}},
+	} {
+		accessData.ExpiresIn = 100
+		accessData.CreatedAt = time.Now()
+		accessData.Client = client
+		if err := store.SaveAccess(accessData); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.RevokeConsent(ctx, "alice", client.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeSubjectToken(ctx, "subjecthook", false); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"consenthook", "subjecthook"}
+	if strings.Join(before, " ") != strings.Join(expected, " ") || strings.Join(after, " ") != strings.Join(expected, " ") {
+		t.Errorf("\"%v\", \"%v\": expected %v", before, after, expected)
+	}
+
+	// A vetoed revocation keeps the token
+	errDenied := errors.New("revocation denied")
+	store.Hooks().BeforeRemoveAccess.Add(func(ctx context.Context, q Querier, token string) error {
+		return errDenied
+	})
+	accessData := &osin.AccessData{AccessToken: "vetoedhook", ExpiresIn: 100, CreatedAt: time.Now(), Client: client}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeSubjectToken(ctx, accessData.AccessToken, false); err != errDenied {
+		t.Fatalf("\"%v\": expected %v", err, errDenied)
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); err != nil {
+		t.Errorf("\"%v\": expected the access data to be kept", err)
+	}
+}
+
+func TestLoadHooksDatabase(t *testing.T) {
+	// The replicas have different scopes for the same tokens
+	readers := []*sql.DB{}
+	for _, scope := range []string{"one", "two", "three"} {
+		reader := openMigratedDB(t)
+		defer reader.Close()
+		seed := NewSQLStorage(reader)
+		client := &osin.DefaultClient{Id: "replicaclient", Secret: "secret", RedirectUri: "redirect"}
+		if err := seed.SetClient(client); err != nil {
+			t.Fatal(err)
+		}
+		accessData := &osin.AccessData{AccessToken: "replicaaccess", RefreshToken: "replicarefresh",
+			ExpiresIn: 100, Scope: scope, CreatedAt: time.Now(), Client: client}
+		if err := seed.SaveAccess(accessData); err != nil {
+			t.Fatal(err)
+		}
+		readers = append(readers, reader)
+	}
+	writer := openMigratedDB(t)
+	defer writer.Close()
+
+	// The hooks receive the replica the data was loaded from
+	store := NewReplicatedSQLStorage(writer, readers)
+	store.Hooks().AfterLoadAccess.Add(func(ctx context.Context, q Querier, accessData *osin.AccessData) error {
+		var scope string
+		row := q.QueryRowContext(ctx, "SELECT scope FROM access_data WHERE access_token = ?", accessData.AccessToken)
+		if err := row.Scan(&scope); err != nil {
+			return err
+		}
+		if scope != accessData.Scope {
+			t.Errorf("Hook database has scope %q, expected %q", scope, accessData.Scope)
+		}
+		return nil
+	})
+	for i := 0; i < 4; i++ {
+		if _, err := store.LoadAccess("replicaaccess"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.LoadRefresh("replicarefresh"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBeforeLoadHooks(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db)
+	client := &osin.DefaultClient{Id: "loadhookclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	authorizeData := &osin.AuthorizeData{Code: "loadhookcode", ExpiresIn: 100, CreatedAt: time.Now(), Client: client}
+	if err := store.SaveAuthorize(authorizeData); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "loadhookaccess", RefreshToken: "loadhookrefresh", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client, AuthorizeData: authorizeData}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+
+	// Before load hooks receive the key and can veto the load
+	errDenied := errors.New("load denied")
+	loaded, denied := []string{}, ""
+	hooks := store.Hooks()
+	for _, list := range []*HookList[string]{&hooks.BeforeLoadClient, &hooks.BeforeLoadAuthorize,
+		&hooks.BeforeLoadAccess, &hooks.BeforeLoadRefresh} {
+		list.Add(func(ctx context.Context, q Querier, key string) error {
+			if key == denied {
+				return errDenied
+			}
+			loaded = append(loaded, key)
+			return nil
+		})
+	}
+	afterLoads := 0
+	hooks.AfterLoadAccess.Add(func(ctx context.Context, q Querier, accessData *osin.AccessData) error {
+		afterLoads++
+		return nil
+	})
+
+	if _, err := store.LoadRefresh(accessData.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{accessData.RefreshToken, client.Id, authorizeData.Code, client.Id}
+	if !reflect.DeepEqual(loaded, expected) {
+		t.Errorf("\"%v\": expected %v", loaded, expected)
+	}
+
+	for _, key := range []string{client.Id, authorizeData.Code, accessData.AccessToken, accessData.RefreshToken} {
+		denied = key
+		var err error
+		switch key {
+		case client.Id:
+			_, err = store.GetClient(key)
+		case authorizeData.Code:
+			_, err = store.LoadAuthorize(key)
+		case accessData.AccessToken:
+			_, err = store.LoadAccess(key)
+		default:
+			_, err = store.LoadRefresh(key)
+		}
+		if err != errDenied {
+			t.Errorf("%s: \"%v\": expected %v", key, err, errDenied)
+		}
+	}
+	if afterLoads != 1 {
+		t.Errorf("%d after load hooks, expected 1", afterLoads)
+	}
+}
@@ -0,0 +1,259 @@
+package sqlstore
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogLevels are the levels of the records logged by a SQLStorage
+type LogLevels struct {
+	// Statement is the level of storage calls and SQL statements
+	Statement slog.Level
+	// Slow is the level of statements that reach the slow query threshold
+	Slow slog.Level
+	// Failure is the level of failed calls and statements. Calls that fail because
+	// a row does not exist or a request was rejected are logged at the Statement level.
+	Failure slog.Level
+}
+
+// DefaultLogLevels are the log levels used unless WithLogLevels is used
+var DefaultLogLevels = LogLevels{Statement: slog.LevelDebug, Slow: slog.LevelWarn, Failure: slog.LevelError}
+
+// redacted replaces the logged values of sensitive statement arguments
+const redacted = "[REDACTED]"
+
+// sensitiveColumnWords mark the columns whose values are never logged: tokens,
+// codes and secrets, and the user data and request parameters of the end user
+var sensitiveColumnWords = []string{"token", "code", "secret", "user_data", "authorization_details", "parameters"}
+
+// WithLogger logs every storage call and SQL statement with its duration and error.
+// Statements are logged with their arguments, except for the values of columns
+// holding tokens, codes, secrets and user data and of arguments whose column is
+// unknown, which are always redacted, also from error messages.
+func WithLogger(logger *slog.Logger) Option {
+	return func(store *SQLStorage) {
+		store.logger = logger
+	}
+}
+
+// WithLogLevels sets the levels of the records logged by WithLogger
+func WithLogLevels(levels LogLevels) Option {
+	return func(store *SQLStorage) {
+		store.logLevels = levels
+	}
+}
+
+// WithSlowQueryThreshold logs statements that take at least threshold at the Slow
+// log level
+func WithSlowQueryThreshold(threshold time.Duration) Option {
+	return func(store *SQLStorage) {
+		store.slowQueryThreshold = threshold
+	}
+}
+
+// logCall logs a call of a storage method
+func (store *SQLStorage) logCall(ctx context.Context, method string, duration time.Duration, err error) {
+	level, msg := store.logLevels.Statement, "sqlstore: call"
+	attrs := []slog.Attr{slog.String("method", method), slog.Duration("duration", duration)}
+	if err != nil {
+		// Error messages are logged with the failed statement, where they are redacted
+		kind := ErrorKind(err)
+		if kind != ErrorKindNotFound && kind != ErrorKindRejected {
+			level, msg = store.logLevels.Failure, "sqlstore: call failed"
+		}
+		attrs = append(attrs, slog.String("error_kind", kind))
+	}
+	if store.logger.Enabled(ctx, level) {
+		store.logger.LogAttrs(ctx, level, msg, attrs...)
+	}
+}
+
+// logStatement logs a SQL statement. rowsAffected is negative if it is not known.
+func (store *SQLStorage) logStatement(ctx context.Context, query string, args []interface{}, duration time.Duration,
+	rowsAffected int64, err error) {
+	level, msg := store.logLevels.Statement, "sqlstore: statement"
+	switch {
+	case err != nil:
+		level, msg = store.logLevels.Failure, "sqlstore: statement failed"
+	case store.slowQueryThreshold > 0 && duration >= store.slowQueryThreshold:
+		level, msg = store.logLevels.Slow, "sqlstore: slow statement"
+	}
+	if !store.logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{}
+	if method, ok := ctx.Value(methodKey{}).(string); ok {
+		attrs = append(attrs, slog.String("method", method))
+	}
+	operation, table := statementTarget(query)
+	attrs = append(attrs, slog.String("operation", operation), slog.String("table", table),
+		slog.String("query", strings.Join(strings.Fields(query), " ")))
+
+	columns := argColumns(query, len(args))
+	argAttrs := make([]slog.Attr, len(args))
+	secrets := []string{}
+	for i, arg := range args {
+		key := columns[i]
+		if key == "" {
+			key = "$" + strconv.Itoa(i+1)
+		}
+		if store.loggable(columns[i]) {
+			argAttrs[i] = slog.Any(key, arg)
+			continue
+		}
+		argAttrs[i] = slog.String(key, redacted)
+		switch value := arg.(type) {
+		case string:
+			secrets = append(secrets, value)
+		case []byte:
+			secrets = append(secrets, string(value))
+		}
+	}
+	attrs = append(attrs, slog.Attr{Key: "args", Value: slog.GroupValue(argAttrs...)},
+		slog.Duration("duration", duration))
+	if rowsAffected >= 0 {
+		attrs = append(attrs, slog.Int64("rows_affected", rowsAffected))
+	}
+	if err != nil {
+		message := err.Error()
+		for _, secret := range secrets {
+			if secret != "" {
+				message = strings.ReplaceAll(message, secret, redacted)
+			}
+		}
+		attrs = append(attrs, slog.String("error", message), slog.String("error_kind", ErrorKind(err)))
+	}
+	store.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// loggable reports whether the values of a column may be logged. column is the
+// name used in the database.
+func (store *SQLStorage) loggable(column string) bool {
+	if column == "" {
+		return false
+	}
+	known := false
+	for _, table := range schemaTables {
+		for _, c := range table.columns {
+			if store.naming.ColumnName(table.name, c.name) != column {
+				continue
+			}
+			for _, word := range sensitiveColumnWords {
+				if strings.Contains(c.name, word) {
+					return false
+				}
+			}
+			known = true
+		}
+	}
+	return known
+}
+
+// argColumns returns the column of each placeholder of a statement in the order
+// of the arguments. Columns that cannot be determined are left empty.
+func argColumns(query string, n int) []string {
+	tokens := sqlTokens(query)
+	columns := make([]string, n)
+
+	// INSERT INTO table(columns) VALUES(placeholders)
+	var insertColumns []string
+	if len(tokens) > 0 && strings.EqualFold(tokens[0], "INSERT") {
+		insertColumns = []string{}
+		for i := indexOf(tokens, "(") + 1; i > 0 && i < len(tokens) && tokens[i] != ")"; i++ {
+			if isIdentifierStart(tokens[i][0]) {
+				insertColumns = append(insertColumns, tokens[i])
+			}
+		}
+	}
+
+	position := 0
+	for i, token := range tokens {
+		if token != "?" && token[0] != '$' {
+			continue
+		}
+		index := position
+		if token[0] == '$' {
+			index, _ = strconv.Atoi(token[1:])
+			index--
+		}
+		position++
+		if index < 0 || index >= n {
+			continue
+		}
+		if insertColumns != nil {
+			if position <= len(insertColumns) {
+				columns[index] = insertColumns[position-1]
+			}
+			continue
+		}
+		columns[index] = precedingColumn(tokens[:i])
+	}
+	return columns
+}
+
+// sqlKeywords are skipped when looking for the column compared with a placeholder
+var sqlKeywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "IN": true, "IS": true, "NULL": true, "LIKE": true, "BETWEEN": true,
+	"SELECT": true, "FROM": true, "SET": true, "WHERE": true, "VALUES": true,
+}
+
+// precedingColumn returns the column compared with a placeholder, which is the
+// last identifier before it that is not a keyword
+func precedingColumn(tokens []string) string {
+	for i := len(tokens) - 1; i >= 0; i-- {
+		token := tokens[i]
+		if isIdentifierStart(token[0]) && !sqlKeywords[strings.ToUpper(token)] {
+			return token
+		}
+	}
+	return ""
+}
+
+// sqlTokens splits a query into identifiers, placeholders and single characters.
+// String literals are replaced by a single quote and quoted identifiers are unquoted.
+func sqlTokens(query string) []string {
+	tokens := []string{}
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				end = len(query) - i - 1
+			}
+			if c == '\'' {
+				tokens = append(tokens, "'")
+			} else if end > 0 {
+				tokens = append(tokens, query[i+1:i+1+end])
+			}
+			i += end + 2
+		case isIdentifierStart(c) || c == '$':
+			end := i + 1
+			for end < len(query) && (isIdentifierStart(query[end]) || query[end] >= '0' && query[end] <= '9') {
+				end++
+			}
+			tokens = append(tokens, query[i:end])
+			i = end
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
+
+// indexOf returns the index of the first occurrence of token, or -1
+func indexOf(tokens []string, token string) int {
+	for i, t := range tokens {
+		if t == token {
+			return i
+		}
+	}
+	return -1
+}
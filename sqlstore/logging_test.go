@@ -0,0 +1,233 @@
+package sqlstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/RangelReale/osin"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingHandler keeps the records logged at any level with their attributes
+// flattened into strings
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []loggedRecord
+}
+
+type loggedRecord struct {
+	level   slog.Level
+	message string
+	attrs   map[string]string
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordingHandler) Handle(_ context.Context, record slog.Record) error {
+	logged := loggedRecord{level: record.Level, message: record.Message, attrs: map[string]string{}}
+	var add func(prefix string, attr slog.Attr)
+	add = func(prefix string, attr slog.Attr) {
+		value := attr.Value.Resolve()
+		if value.Kind() == slog.KindGroup {
+			for _, member := range value.Group() {
+				add(prefix+attr.Key+".", member)
+			}
+			return
+		}
+		logged.attrs[prefix+attr.Key] = value.String()
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		add("", attr)
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, logged)
+	return nil
+}
+
+func (h *recordingHandler) find(message string) []loggedRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	found := []loggedRecord{}
+	for _, record := range h.records {
+		if record.message == message {
+			found = append(found, record)
+		}
+	}
+	return found
+}
+
+func TestLoggingRedaction(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	handler := &recordingHandler{}
+	store := NewSQLStorage(db, WithLogger(slog.New(handler)))
+
+	client := &osin.DefaultClient{Id: "logclient", Secret: "secret-value", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	authorizeData := &osin.AuthorizeData{Code: "code-value", ExpiresIn: 100, CreatedAt: time.Now(), Client: client,
+		CodeChallenge: "challenge-value"}
+	if err := store.SaveAuthorize(authorizeData); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "access-value", RefreshToken: "refresh-value", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client, AuthorizeData: authorizeData}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadRefresh(accessData.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveRefresh(accessData.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveAuthorize(authorizeData.Code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ConsumePushedRequest(context.Background(), "uri", client.Id); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if err := store.SaveDeviceCode(context.Background(), &DeviceCode{DeviceCode: "device-value",
+		UserCode: "user-code-value", ClientID: client.Id, ExpiresIn: 100, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Drivers may quote arguments in error messages
+	store.logStatement(context.Background(), "DELETE FROM access_data WHERE tenant_id = ? AND access_token = ?",
+		[]interface{}{"", "access-value"}, time.Millisecond, -1, errors.New("duplicate entry 'access-value'"))
+
+	// Renamed columns are redacted by their default names
+	renamed := NewSQLStorage(db, WithLogger(slog.New(handler)),
+		WithNaming(Naming{Columns: map[string]string{"access_data.access_token": "scope"}}))
+	renamed.logStatement(context.Background(), renamed.rebind(
+		"SELECT scope FROM access_data WHERE tenant_id = ? AND access_token = ?"),
+		[]interface{}{"", "renamed-value"}, time.Millisecond, -1, nil)
+
+	secrets := []string{"secret-value", "code-value", "challenge-value", "access-value", "refresh-value",
+		"device-value", "user-code-value", "renamed-value"}
+	if len(handler.records) == 0 {
+		t.Fatal("Nothing logged")
+	}
+	for _, record := range handler.records {
+		values := []string{record.message}
+		for _, value := range record.attrs {
+			values = append(values, value)
+		}
+		for _, value := range values {
+			for _, secret := range secrets {
+				if strings.Contains(value, secret) {
+					t.Errorf("%s %v: contains %s", record.message, record.attrs, secret)
+				}
+			}
+		}
+	}
+
+	// Other arguments are logged
+	found := false
+	for _, record := range handler.find("sqlstore: statement") {
+		if record.attrs["method"] == "SetClient" && record.attrs["args.redirect_uri"] == "redirect" &&
+			record.attrs["args.secret"] == redacted && record.attrs["table"] == "clients" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("No SetClient statement in %v", handler.records)
+	}
+}
+
+func TestLoggingLevels(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	handler := &recordingHandler{}
+	store := NewSQLStorage(db, WithLogger(slog.New(handler)), WithSlowQueryThreshold(time.Nanosecond),
+		WithLogLevels(LogLevels{Statement: slog.LevelInfo, Slow: slog.LevelWarn + 1, Failure: slog.LevelError}))
+
+	if _, err := store.LoadAccess("missing"); err == nil {
+		t.Fatal("Expected an error")
+	}
+	slow := handler.find("sqlstore: slow statement")
+	if len(slow) == 0 || slow[0].level != slog.LevelWarn+1 || slow[0].attrs["duration"] == "" {
+		t.Errorf("Unexpected slow statements %v", slow)
+	}
+	calls := handler.find("sqlstore: call")
+	if len(calls) != 1 || calls[0].level != slog.LevelInfo || calls[0].attrs["error_kind"] != ErrorKindNotFound {
+		t.Errorf("Unexpected calls %v", calls)
+	}
+
+	store.logStatement(context.Background(), "SELECT", nil, 0, -1, errors.New("failed"))
+	failed := handler.find("sqlstore: statement failed")
+	if len(failed) != 1 || failed[0].level != slog.LevelError || failed[0].attrs["error"] != "failed" {
+		t.Errorf("Unexpected failed statements %v", failed)
+	}
+}
+
+func TestArgColumns(t *testing.T) {
+	for query, expected := range map[string][]string{
+		"INSERT INTO clients(tenant_id, id, secret) VALUES(?, ?, ?)":                 {"tenant_id", "id", "secret"},
+		"DELETE FROM t WHERE tenant_id = $1 AND expires_at <= $2":                    {"tenant_id", "expires_at"},
+		"UPDATE t SET status = ? WHERE tenant_id = ? AND code IN (?, ?)":             {"status", "tenant_id", "code", "code"},
+		"SELECT a FROM t WHERE \"tenant_id\" = ? AND json_extract(user_data, ?) = ?": {"tenant_id", "user_data", "user_data"},
+		"SELECT ?":       {""},
+		"SELECT '?' = ?": {""},
+	} {
+		if columns := argColumns(query, len(expected)); !reflect.DeepEqual(columns, expected) {
+			t.Errorf("%q: %v, expected %v", query, columns, expected)
+		}
+	}
+}
+
+func TestLoggingUserDataRedaction(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	output := &bytes.Buffer{}
+	store := NewSQLStorage(db, WithLogger(slog.New(slog.NewTextHandler(output,
+		&slog.HandlerOptions{Level: slog.LevelDebug}))), WithAuthorizationDetailType("payment_initiation",
+		func() AuthorizationDetail { return &paymentInitiation{} }, nil))
+	client := &osin.DefaultClient{Id: "logclient", Secret: "secret-value", RedirectUri: "redirect",
+		UserData: map[string]interface{}{"owner": "client-owner-value"}}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "access-value", RefreshToken: "refresh-value", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client, UserData: &ExtendedUserData{
+			UserData: map[string]interface{}{"email": "alice@example.com"},
+			AuthorizationDetails: []AuthorizationDetail{
+				&paymentInitiation{Type: "payment_initiation", CreditorName: "creditor-value"}},
+		}}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.QueryByUserData(context.Background(), "access_data", "$.email", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SavePushedRequest(context.Background(), &PushedRequest{RequestURI: "urn:request-uri-value",
+		ClientID: client.Id, Parameters: url.Values{"login_hint": {"hint-value"}}, ExpiresIn: 60,
+		CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output.String(), redacted) {
+		t.Fatalf("Nothing redacted in %s", output)
+	}
+	for _, value := range []string{"secret-value", "client-owner-value", "access-value", "refresh-value",
+		"alice@example.com", "creditor-value", "hint-value"} {
+		if strings.Contains(output.String(), value) {
+			t.Errorf("Log output contains %s", value)
+		}
+	}
+}
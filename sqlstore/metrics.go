@@ -0,0 +1,179 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Error kinds reported to Metrics.IncError
+const (
+	ErrorKindNotFound = "not_found"
+	ErrorKindRejected = "rejected"
+	ErrorKindCanceled = "canceled"
+	ErrorKindTimeout  = "timeout"
+	ErrorKindSchema   = "schema"
+	ErrorKindDatabase = "database"
+)
+
+// Metrics receives the measurements of a SQLStorage configured with WithMetrics.
+// Implementations adapt them to a metrics library, for example a Prometheus
+// HistogramVec and CounterVecs, or expvar with ExpvarMetrics. Implementations must
+// be safe for concurrent use.
+type Metrics interface {
+	// ObserveLatency records the duration of a call of a storage method
+	ObserveLatency(method string, duration time.Duration)
+	// IncError counts a failed call of a storage method by the kind of its error
+	IncError(method, kind string)
+	// AddRowsPurged counts rows removed from a table by a purge
+	AddRowsPurged(table string, rows int64)
+	// IncCacheResult counts a lookup of an entity (client or access) in the cache
+	// of a CachedStorage. The hit ratio is hits / (hits + misses).
+	IncCacheResult(entity string, hit bool)
+}
+
+// WithMetrics records the latency and errors of every storage method, the rows
+// removed by purges and the cache results of a CachedStorage
+func WithMetrics(metrics Metrics) Option {
+	return func(store *SQLStorage) {
+		store.metrics = metrics
+	}
+}
+
+// ErrorKind classifies an error returned by the storage for metrics
+func ErrorKind(err error) string {
+	var schemaErr *SchemaError
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrorKindNotFound
+	case errors.Is(err, context.Canceled):
+		return ErrorKindCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorKindTimeout
+	case errors.As(err, &schemaErr):
+		return ErrorKindSchema
+	}
+	for _, rejected := range []error{ErrAuthorizationPending, ErrSlowDown, ErrAccessDenied, ErrExpiredToken,
+		ErrDPoPReplay, ErrInvalidTarget, ErrInvalidAuthorizationDetails} {
+		if errors.Is(err, rejected) {
+			return ErrorKindRejected
+		}
+	}
+	return ErrorKindDatabase
+}
+
+// purged records rows removed by a purge
+func (store *SQLStorage) purged(table string, rows int64) {
+	if store.metrics != nil && rows > 0 {
+		store.metrics.AddRowsPurged(table, rows)
+	}
+}
+
+// cacheResult records a cache lookup of a CachedStorage
+func (store *SQLStorage) cacheResult(entity string, hit bool) {
+	if store.metrics != nil {
+		store.metrics.IncCacheResult(entity, hit)
+	}
+}
+
+// DefaultLatencyBuckets are the upper bounds of the latency histogram buckets of
+// ExpvarMetrics
+var DefaultLatencyBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second,
+}
+
+// ExpvarMetrics is a Metrics implementation backed by an expvar.Map, which can be
+// published with expvar.Publish. The map holds:
+//
+//	latency:       method -> {le_<seconds>: cumulative count, +Inf, sum_seconds}
+//	errors:        method -> {kind: count}
+//	rows_purged:   table -> count
+//	cache_hits:    entity -> count
+//	cache_misses:  entity -> count
+type ExpvarMetrics struct {
+	*expvar.Map
+
+	buckets []time.Duration
+
+	mu      sync.Mutex
+	latency map[string]*expvarHistogram
+	errors  map[string]*expvar.Map
+}
+
+// expvarHistogram is the latency histogram of a method
+type expvarHistogram struct {
+	buckets []*expvar.Int
+	count   *expvar.Int
+	sum     *expvar.Float
+}
+
+// NewExpvarMetrics returns an ExpvarMetrics with DefaultLatencyBuckets
+func NewExpvarMetrics() *ExpvarMetrics {
+	m := &ExpvarMetrics{
+		Map:     new(expvar.Map).Init(),
+		buckets: DefaultLatencyBuckets,
+		latency: map[string]*expvarHistogram{},
+		errors:  map[string]*expvar.Map{},
+	}
+	for _, name := range []string{"latency", "errors", "rows_purged", "cache_hits", "cache_misses"} {
+		m.Set(name, new(expvar.Map).Init())
+	}
+	return m
+}
+
+func (m *ExpvarMetrics) ObserveLatency(method string, duration time.Duration) {
+	m.mu.Lock()
+	histogram, ok := m.latency[method]
+	if !ok {
+		histogram = &expvarHistogram{count: new(expvar.Int), sum: new(expvar.Float)}
+		methodMap := new(expvar.Map).Init()
+		for _, bucket := range m.buckets {
+			v := new(expvar.Int)
+			histogram.buckets = append(histogram.buckets, v)
+			methodMap.Set("le_"+strconv.FormatFloat(bucket.Seconds(), 'f', -1, 64), v)
+		}
+		methodMap.Set("+Inf", histogram.count)
+		methodMap.Set("sum_seconds", histogram.sum)
+		m.Get("latency").(*expvar.Map).Set(method, methodMap)
+		m.latency[method] = histogram
+	}
+	m.mu.Unlock()
+
+	for i, bucket := range m.buckets {
+		if duration <= bucket {
+			histogram.buckets[i].Add(1)
+		}
+	}
+	histogram.count.Add(1)
+	histogram.sum.Add(duration.Seconds())
+}
+
+func (m *ExpvarMetrics) IncError(method, kind string) {
+	m.mu.Lock()
+	kinds, ok := m.errors[method]
+	if !ok {
+		kinds = new(expvar.Map).Init()
+		m.Get("errors").(*expvar.Map).Set(method, kinds)
+		m.errors[method] = kinds
+	}
+	m.mu.Unlock()
+
+	kinds.Add(kind, 1)
+}
+
+func (m *ExpvarMetrics) AddRowsPurged(table string, rows int64) {
+	m.Get("rows_purged").(*expvar.Map).Add(table, rows)
+}
+
+func (m *ExpvarMetrics) IncCacheResult(entity string, hit bool) {
+	if hit {
+		m.Get("cache_hits").(*expvar.Map).Add(entity, 1)
+	} else {
+		m.Get("cache_misses").(*expvar.Map).Add(entity, 1)
+	}
+}
@@ -0,0 +1,153 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/RangelReale/osin"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryMetrics is an in-memory Metrics registry
+type memoryMetrics struct {
+	mu          sync.Mutex
+	calls       map[string]int
+	errors      map[string]int
+	rowsPurged  map[string]int64
+	cacheHits   map[string]int
+	cacheMisses map[string]int
+}
+
+func newMemoryMetrics() *memoryMetrics {
+	return &memoryMetrics{
+		calls:       map[string]int{},
+		errors:      map[string]int{},
+		rowsPurged:  map[string]int64{},
+		cacheHits:   map[string]int{},
+		cacheMisses: map[string]int{},
+	}
+}
+
+func (m *memoryMetrics) ObserveLatency(method string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[method]++
+}
+
+func (m *memoryMetrics) IncError(method, kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[method+"/"+kind]++
+}
+
+func (m *memoryMetrics) AddRowsPurged(table string, rows int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rowsPurged[table] += rows
+}
+
+func (m *memoryMetrics) IncCacheResult(entity string, hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.cacheHits[entity]++
+	} else {
+		m.cacheMisses[entity]++
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	metrics := newMemoryMetrics()
+	store := NewSQLStorage(db, WithMetrics(metrics))
+
+	client := &osin.DefaultClient{Id: "metricsclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetClient(client.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadAccess("missing"); err != sql.ErrNoRows {
+		t.Fatalf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+	if err := store.RecordDPoPProof(ctx, "jti", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RecordDPoPProof(ctx, "jti", time.Hour); err != ErrDPoPReplay {
+		t.Fatalf("\"%v\": expected %v", err, ErrDPoPReplay)
+	}
+	if err := store.RecordDPoPProof(ctx, "expired", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PurgeDPoPProofs(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for method, calls := range map[string]int{"SetClient": 1, "GetClient": 1, "LoadAccess": 1, "RecordDPoPProof": 3} {
+		if metrics.calls[method] != calls {
+			t.Errorf("%s: %d calls, expected %d", method, metrics.calls[method], calls)
+		}
+	}
+	for key, count := range map[string]int{"LoadAccess/not_found": 1, "RecordDPoPProof/rejected": 1} {
+		if metrics.errors[key] != count {
+			t.Errorf("%s: %d errors, expected %d", key, metrics.errors[key], count)
+		}
+	}
+	if len(metrics.errors) != 2 {
+		t.Errorf("Unexpected errors %v", metrics.errors)
+	}
+	if metrics.rowsPurged["dpop_jti"] != 1 {
+		t.Errorf("%d rows purged, expected 1", metrics.rowsPurged["dpop_jti"])
+	}
+
+	cached := NewCachedStorage(store, NewLRUCache(10))
+	for i := 0; i < 3; i++ {
+		if _, err := cached.GetClient(client.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if metrics.cacheHits["client"] != 2 || metrics.cacheMisses["client"] != 1 {
+		t.Errorf("%d hits and %d misses, expected 2 and 1", metrics.cacheHits["client"], metrics.cacheMisses["client"])
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	metrics := NewExpvarMetrics()
+	metrics.ObserveLatency("GetClient", 3*time.Millisecond)
+	metrics.ObserveLatency("GetClient", 2*time.Second)
+	metrics.IncError("GetClient", ErrorKindNotFound)
+	metrics.AddRowsPurged("dpop_jti", 4)
+	metrics.IncCacheResult("client", true)
+	metrics.IncCacheResult("client", false)
+
+	var value struct {
+		Latency     map[string]map[string]float64 `json:"latency"`
+		Errors      map[string]map[string]int     `json:"errors"`
+		RowsPurged  map[string]int                `json:"rows_purged"`
+		CacheHits   map[string]int                `json:"cache_hits"`
+		CacheMisses map[string]int                `json:"cache_misses"`
+	}
+	if err := json.Unmarshal([]byte(metrics.String()), &value); err != nil {
+		t.Fatal(err)
+	}
+
+	latency := value.Latency["GetClient"]
+	if latency["le_0.001"] != 0 || latency["le_0.005"] != 1 || latency["le_1"] != 1 || latency["+Inf"] != 2 {
+		t.Errorf("Unexpected histogram %v", latency)
+	}
+	if value.Errors["GetClient"][ErrorKindNotFound] != 1 {
+		t.Errorf("Unexpected errors %v", value.Errors)
+	}
+	if value.RowsPurged["dpop_jti"] != 4 {
+		t.Errorf("Unexpected rows purged %v", value.RowsPurged)
+	}
+	if value.CacheHits["client"] != 1 || value.CacheMisses["client"] != 1 {
+		t.Errorf("Unexpected cache results %v %v", value.CacheHits, value.CacheMisses)
+	}
+}
@@ -0,0 +1,500 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// column describes a column in the migration DDL
+type column struct {
+	name string
+	typ  columnType
+}
+
+// migration is a single versioned change to the schema. Migrations are applied
+// in order and must be idempotent, so that they can run against tables that
+// were created by other means (for example gorm's AutoMigrate with the
+// models in gorm_schema).
+type migration struct {
+	version     int
+	description string
+	up          func(ctx context.Context, store *SQLStorage) error
+}
+
+var migrations = []migration{
+	{1, "create clients, authorize_data and access_data", func(ctx context.Context, store *SQLStorage) error {
+		err := store.createTable(ctx, "clients", []column{
+			{"id", keyColumn},
+			{"secret", textColumn},
+			{"redirect_uri", textColumn},
+			{"user_data", jsonColumn},
+		}, "id")
+		if err != nil {
+			return err
+		}
+
+		err = store.createTable(ctx, "authorize_data", []column{
+			{"code", keyColumn},
+			{"expires_in", intColumn},
+			{"scope", textColumn},
+			{"redirect_uri", textColumn},
+			{"state", textColumn},
+			{"created_at", timeColumn},
+			{"user_data", jsonColumn},
+			{"client_id", keyColumn},
+		}, "code")
+		if err != nil {
+			return err
+		}
+
+		err = store.createTable(ctx, "access_data", []column{
+			{"access_token", keyColumn},
+			{"refresh_token", keyColumn},
+			{"expires_in", intColumn},
+			{"scope", textColumn},
+			{"redirect_uri", textColumn},
+			{"created_at", timeColumn},
+			{"user_data", jsonColumn},
+			{"authorize_data_code", keyColumn},
+			{"prev_access_data_token", keyColumn},
+			{"client_id", keyColumn},
+		}, "access_token")
+		if err != nil {
+			return err
+		}
+
+		// Index names match the ones created by gorm for the gorm_schema models
+		for _, index := range []struct{ table, column string }{
+			{"authorize_data", "client_id"},
+			{"access_data", "refresh_token"},
+			{"access_data", "authorize_data_code"},
+			{"access_data", "prev_access_data_token"},
+			{"access_data", "client_id"},
+		} {
+			if err := store.createIndex(ctx, index.table, index.column); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{2, "add PKCE code challenge to authorize_data", func(ctx context.Context, store *SQLStorage) error {
+		if err := store.addColumn(ctx, "authorize_data", column{"code_challenge", textColumn}); err != nil {
+			return err
+		}
+		return store.addColumn(ctx, "authorize_data", column{"code_challenge_method", textColumn})
+	}},
+	{3, "add OpenID Connect columns to authorize_data", func(ctx context.Context, store *SQLStorage) error {
+		for _, c := range []column{
+			{"nonce", textColumn},
+			{"auth_time", timeColumn},
+			{"acr", textColumn},
+			{"amr", textColumn},
+			{"claims", jsonColumn},
+		} {
+			if err := store.addColumn(ctx, "authorize_data", c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{4, "add subject to authorize_data and access_data, create consents", func(ctx context.Context, store *SQLStorage) error {
+		for _, table := range []string{"authorize_data", "access_data"} {
+			if err := store.addColumn(ctx, table, column{"subject", keyColumn}); err != nil {
+				return err
+			}
+			if err := store.createIndex(ctx, table, "subject"); err != nil {
+				return err
+			}
+		}
+
+		return store.createTable(ctx, "consents", []column{
+			{"subject", keyColumn},
+			{"client_id", keyColumn},
+			{"scope", textColumn},
+			{"granted_at", timeColumn},
+			{"expires_at", timeColumn},
+		}, "subject", "client_id")
+	}},
+	{5, "create device_codes", func(ctx context.Context, store *SQLStorage) error {
+		err := store.createTable(ctx, "device_codes", []column{
+			{"device_code", keyColumn},
+			{"user_code", keyColumn},
+			{"client_id", keyColumn},
+			{"scope", textColumn},
+			{"expires_in", intColumn},
+			{"poll_interval", intColumn},
+			{"created_at", timeColumn},
+			{"status", keyColumn},
+			{"subject", keyColumn},
+			{"user_data", jsonColumn},
+			{"last_polled_at", timeColumn},
+		}, "device_code")
+		if err != nil {
+			return err
+		}
+		return store.createIndex(ctx, "device_codes", "user_code")
+	}},
+	{6, "create par_requests", func(ctx context.Context, store *SQLStorage) error {
+		return store.createTable(ctx, "par_requests", []column{
+			{"request_uri", keyColumn},
+			{"client_id", keyColumn},
+			{"parameters", textColumn},
+			{"expires_in", intColumn},
+			{"created_at", timeColumn},
+		}, "request_uri")
+	}},
+	{7, "add cnf to access_data, create dpop_jti", func(ctx context.Context, store *SQLStorage) error {
+		if err := store.addColumn(ctx, "access_data", column{"cnf", jsonColumn}); err != nil {
+			return err
+		}
+		err := store.createTable(ctx, "dpop_jti", []column{
+			{"jti", keyColumn},
+			{"expires_at", timeColumn},
+		}, "jti")
+		if err != nil {
+			return err
+		}
+		return store.createIndex(ctx, "dpop_jti", "expires_at")
+	}},
+	{8, "add audience to authorize_data and access_data", func(ctx context.Context, store *SQLStorage) error {
+		if err := store.addColumn(ctx, "authorize_data", column{"audience", textColumn}); err != nil {
+			return err
+		}
+		return store.addColumn(ctx, "access_data", column{"audience", textColumn})
+	}},
+	{9, "add authorization_details to authorize_data and access_data", func(ctx context.Context, store *SQLStorage) error {
+		if err := store.addColumn(ctx, "authorize_data", column{"authorization_details", jsonColumn}); err != nil {
+			return err
+		}
+		return store.addColumn(ctx, "access_data", column{"authorization_details", jsonColumn})
+	}},
+	{10, "add token exchange columns to access_data", func(ctx context.Context, store *SQLStorage) error {
+		for _, c := range []column{
+			{"subject_token", keyColumn},
+			{"actor_token", keyColumn},
+			{"issued_token_type", textColumn},
+		} {
+			if err := store.addColumn(ctx, "access_data", c); err != nil {
+				return err
+			}
+		}
+		if err := store.createIndex(ctx, "access_data", "subject_token"); err != nil {
+			return err
+		}
+		return store.createIndex(ctx, "access_data", "actor_token")
+	}},
+	{11, "add tenant_id to all tables and make it part of the primary keys", func(ctx context.Context, store *SQLStorage) error {
+		for _, table := range []struct {
+			name string
+			key  []string
+		}{
+			{"clients", []string{"id"}},
+			{"authorize_data", []string{"code"}},
+			{"access_data", []string{"access_token"}},
+			{"consents", []string{"subject", "client_id"}},
+			{"device_codes", []string{"device_code"}},
+			{"par_requests", []string{"request_uri"}},
+			{"dpop_jti", []string{"jti"}},
+		} {
+			if err := store.addColumn(ctx, table.name, column{"tenant_id", tenantColumn}); err != nil {
+				return err
+			}
+			if err := store.setPrimaryKey(ctx, table.name, append([]string{"tenant_id"}, table.key...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{12, "create audit_events", func(ctx context.Context, store *SQLStorage) error {
+		err := store.createTable(ctx, "audit_events", []column{
+			{"tenant_id", tenantColumn},
+			{"id", keyColumn},
+			{"event_type", keyColumn},
+			{"client_id", keyColumn},
+			{"subject", keyColumn},
+			{"token_fingerprint", keyColumn},
+			{"created_at", timeColumn},
+		}, "tenant_id", "id")
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"client_id", "subject", "created_at"} {
+			if err := store.createIndex(ctx, "audit_events", name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{13, "create outbox_events", func(ctx context.Context, store *SQLStorage) error {
+		err := store.createTable(ctx, "outbox_events", []column{
+			{"tenant_id", tenantColumn},
+			{"id", keyColumn},
+			{"event_type", keyColumn},
+			{"client_id", keyColumn},
+			{"subject", keyColumn},
+			{"token_fingerprint", keyColumn},
+			{"created_at", timeColumn},
+		}, "tenant_id", "id")
+		if err != nil {
+			return err
+		}
+		return store.createIndex(ctx, "outbox_events", "created_at")
+	}},
+	{14, "add revoked_at and revoked_reason to authorize_data and access_data", func(ctx context.Context, store *SQLStorage) error {
+		for _, table := range []string{"authorize_data", "access_data"} {
+			if err := store.addColumn(ctx, table, column{"revoked_at", timeColumn}); err != nil {
+				return err
+			}
+			if err := store.addColumn(ctx, table, column{"revoked_reason", textColumn}); err != nil {
+				return err
+			}
+			if err := store.createIndex(ctx, table, "revoked_at"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{15, "add expires_at to authorize_data and access_data", func(ctx context.Context, store *SQLStorage) error {
+		for _, table := range []string{"authorize_data", "access_data"} {
+			if err := store.addColumn(ctx, table, column{"expires_at", timeColumn}); err != nil {
+				return err
+			}
+			if err := store.createIndex(ctx, table, "expires_at"); err != nil {
+				return err
+			}
+			if err := store.backfillExpiresAt(ctx, table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{16, "normalize device user codes and make them unique per tenant", func(ctx context.Context, store *SQLStorage) error {
+		_, err := store.exec(ctx, store.authDB, store.rebind(
+			"UPDATE device_codes SET user_code = UPPER(REPLACE(REPLACE(user_code, '-', ''), ' ', ''))"))
+		if err != nil {
+			return err
+		}
+		return store.createUniqueIndex(ctx, "device_codes", "tenant_id", "user_code")
+	}},
+}
+
+// Migrate creates or updates the tables used by the storage. The applied
+// versions are recorded in the schema_migrations table of the token database,
+// so Migrate can be called every time the application starts. Concurrent calls from several
+// processes are not coordinated.
+func (store *SQLStorage) Migrate(ctx context.Context) (err error) {
+	ctx, op := store.begin(ctx, "Migrate")
+	defer op.end(&err)
+	_, err = store.exec(ctx, store.authDB,
+		store.rebind("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"))
+	if err != nil {
+		return err
+	}
+
+	var current int
+	row := store.queryRow(ctx, store.authDB, store.rebind("SELECT COALESCE(MAX(version), 0) FROM schema_migrations"))
+	if err := row.Scan(&current); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := m.up(ctx, store); err != nil {
+			return err
+		}
+		_, err := store.exec(ctx, store.authDB,
+			store.rebind("INSERT INTO schema_migrations(version) VALUES(?)"), m.version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableDB returns the database that stores table
+func (store *SQLStorage) tableDB(table string) *sql.DB {
+	if table == "clients" {
+		return store.clientDB
+	}
+	return store.authDB
+}
+
+// The migration helpers take the default table and column names and apply the
+// naming of the storage.
+
+// createTable creates a table if it does not exist yet
+func (store *SQLStorage) createTable(ctx context.Context, table string, columns []column, primaryKey ...string) error {
+	defs := []string{}
+	for _, c := range columns {
+		defs = append(defs, c.name+" "+store.dialect.sqlType(c.typ, store.nativeJSON))
+	}
+	if len(primaryKey) > 0 {
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(primaryKey, ", ")+")")
+	}
+
+	_, err := store.exec(ctx, store.tableDB(table),
+		store.naming.rewrite("CREATE TABLE IF NOT EXISTS "+table+" (\n\t"+strings.Join(defs, ",\n\t")+"\n)"))
+	return err
+}
+
+// createIndex creates the index idx_<table>_<column> if it does not exist yet
+func (store *SQLStorage) createIndex(ctx context.Context, table, column string) error {
+	name := "idx_" + store.naming.bareTable(table) + "_" + store.naming.ColumnName(table, column)
+	return store.addIndex(ctx, "CREATE INDEX", name, table, column)
+}
+
+// createUniqueIndex creates a unique index on columns of table if it does not exist yet
+func (store *SQLStorage) createUniqueIndex(ctx context.Context, table string, columns ...string) error {
+	names := []string{}
+	for _, column := range columns {
+		names = append(names, store.naming.ColumnName(table, column))
+	}
+	name := "uidx_" + store.naming.bareTable(table) + "_" + strings.Join(names, "_")
+	return store.addIndex(ctx, "CREATE UNIQUE INDEX", name, table, strings.Join(columns, ", "))
+}
+
+// addIndex runs a CREATE [UNIQUE] INDEX statement unless the index exists
+func (store *SQLStorage) addIndex(ctx context.Context, create, name, table, columns string) error {
+	// MySQL has no CREATE INDEX IF NOT EXISTS
+	if store.dialect == MySQL {
+		_, err := store.exec(ctx, store.tableDB(table),
+			store.naming.rewrite(create+" "+name+" ON "+table+" ("+columns+")"))
+		if err != nil && strings.Contains(err.Error(), "Duplicate key name") {
+			return nil
+		}
+		return err
+	}
+
+	_, err := store.exec(ctx, store.tableDB(table),
+		store.naming.rewrite(create+" IF NOT EXISTS "+name+" ON "+table+" ("+columns+")"))
+	return err
+}
+
+// setPrimaryKey replaces the primary key of table. SQLite can not alter primary
+// keys, so the table is rebuilt with the new key.
+func (store *SQLStorage) setPrimaryKey(ctx context.Context, table string, key []string) error {
+	db := store.tableDB(table)
+	switch store.dialect {
+	case Postgres:
+		_, err := store.exec(ctx, db, store.naming.rewrite("ALTER TABLE "+table+
+			" DROP CONSTRAINT IF EXISTS "+store.naming.bareTable(table)+"_pkey, "+
+			"ADD PRIMARY KEY ("+strings.Join(key, ", ")+")"))
+		return err
+	case MySQL:
+		_, err := store.exec(ctx, db, store.naming.rewrite("ALTER TABLE "+table+" DROP PRIMARY KEY, "+
+			"ADD PRIMARY KEY ("+strings.Join(key, ", ")+")"))
+		return err
+	}
+
+	// The table is rebuilt with the names of the existing columns
+	name := store.naming.TableName(table)
+	physicalKey := []string{}
+	for _, column := range key {
+		physicalKey = append(physicalKey, store.naming.ColumnName(table, column))
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := store.query(ctx, tx, "PRAGMA table_info("+name+")")
+	if err != nil {
+		return err
+	}
+	var (
+		names   []string
+		defs    []string
+		current = map[int]string{}
+	)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		def := name + " " + typ
+		if notNull != 0 {
+			def += " NOT NULL"
+		}
+		if dflt.Valid {
+			def += " DEFAULT " + dflt.String
+		}
+		names = append(names, name)
+		defs = append(defs, def)
+		if pk > 0 {
+			current[pk-1] = name
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(current) == len(physicalKey) {
+		same := true
+		for i, column := range physicalKey {
+			same = same && current[i] == column
+		}
+		if same {
+			return nil
+		}
+	}
+
+	// The indexes are dropped with the table and created again afterwards
+	indexes, err := store.queryStrings(ctx, tx,
+		"SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", name)
+	if err != nil {
+		return err
+	}
+
+	defs = append(defs, "PRIMARY KEY ("+strings.Join(physicalKey, ", ")+")")
+	columns := strings.Join(names, ", ")
+	for _, stmt := range []string{
+		"CREATE TABLE " + name + "_rebuild (\n\t" + strings.Join(defs, ",\n\t") + "\n)",
+		"INSERT INTO " + name + "_rebuild (" + columns + ") SELECT " + columns + " FROM " + name,
+		"DROP TABLE " + name,
+		"ALTER TABLE " + name + "_rebuild RENAME TO " + name,
+	} {
+		if _, err := store.exec(ctx, tx, stmt); err != nil {
+			return err
+		}
+	}
+	for _, index := range indexes {
+		if _, err := store.exec(ctx, tx, index); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// hasColumn reports whether table has the given column
+func (store *SQLStorage) hasColumn(ctx context.Context, table, column string) bool {
+	rows, err := store.query(ctx, store.tableDB(table),
+		store.naming.rewrite("SELECT "+column+" FROM "+table+" WHERE 1 = 0"))
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// addColumn adds a column to table if it does not have it yet. Added columns must
+// also be added to schemaTables.
+func (store *SQLStorage) addColumn(ctx context.Context, table string, c column) error {
+	if store.hasColumn(ctx, table, c.name) {
+		return nil
+	}
+
+	_, err := store.exec(ctx, store.tableDB(table), store.naming.rewrite(
+		"ALTER TABLE "+table+" ADD COLUMN "+c.name+" "+store.dialect.sqlType(c.typ, store.nativeJSON)))
+	return err
+}
@@ -0,0 +1,73 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/RangelReale/osin"
+	"reflect"
+	"testing"
+)
+
+// openMemoryDB opens an empty in-memory sqlite database
+func openMemoryDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: opens a different database
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+// openMigratedDB opens an in-memory sqlite database with the migrated schema
+func openMigratedDB(t *testing.T) *sql.DB {
+	db := openMemoryDB(t)
+	if err := NewSQLStorage(db).Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db := openMemoryDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db, WithJSONUserData())
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Migrating again should be a no-op
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var version int
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if expected := migrations[len(migrations)-1].version; version != expected {
+		t.Errorf("\"%v\": expected %v", version, expected)
+	}
+
+	client := &osin.DefaultClient{Id: "migrated", Secret: "secret", RedirectUri: "redirect", UserData: nil}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	retClient, err := store.GetClient(client.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(retClient, client) {
+		t.Errorf("\"%v\": expected %v", retClient, client)
+	}
+
+	// Missing user data is stored as NULL with native json columns
+	var userData sql.NullString
+	if err := db.QueryRow("SELECT user_data FROM clients WHERE id = ?", client.Id).Scan(&userData); err != nil {
+		t.Fatal(err)
+	}
+	if userData.Valid {
+		t.Errorf("\"%v\": expected NULL", userData.String)
+	}
+}
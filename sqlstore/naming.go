@@ -0,0 +1,142 @@
+package sqlstore
+
+import (
+	"strings"
+)
+
+// tableNames are the default names of the tables used by the storage
+var tableNames = []string{
+	"clients", "authorize_data", "access_data", "consents", "device_codes", "par_requests", "dpop_jti",
+	"audit_events", "outbox_events", "schema_migrations",
+}
+
+// Naming configures the names of the tables and columns of a SQLStorage. The zero
+// value uses the default names documented in sqlstorage.go. Tables and columns are
+// always referred to by their default names in the configuration.
+type Naming struct {
+	// Schema qualifies all tables, for example with a Postgres schema or a MySQL
+	// database. It is not supported with SQLite.
+	Schema string
+
+	// TablePrefix is prepended to the default table names, for example oauth_
+	TablePrefix string
+
+	// Tables maps default table names to names that are used instead of the
+	// prefixed default name
+	Tables map[string]string
+
+	// Columns maps default column names to custom names. Keys are either the
+	// column name, which renames the column in all tables, or table.column,
+	// which takes precedence.
+	Columns map[string]string
+}
+
+// WithNaming sets the table and column names used by all queries and by Migrate
+func WithNaming(naming Naming) Option {
+	return func(store *SQLStorage) {
+		store.naming = naming
+	}
+}
+
+// TableName returns the qualified name of a table by its default name. It can be
+// assigned to gorm_schema.TableNameFunc so that the gorm models use the same
+// table names.
+func (naming Naming) TableName(table string) string {
+	if naming.Schema == "" {
+		return naming.bareTable(table)
+	}
+	return naming.Schema + "." + naming.bareTable(table)
+}
+
+// bareTable returns the name of a table without the schema
+func (naming Naming) bareTable(table string) string {
+	if name, ok := naming.Tables[table]; ok {
+		return name
+	}
+	return naming.TablePrefix + table
+}
+
+// ColumnName returns the name of a column of a table by their default names
+func (naming Naming) ColumnName(table, column string) string {
+	if name, ok := naming.Columns[table+"."+column]; ok {
+		return name
+	}
+	if name, ok := naming.Columns[column]; ok {
+		return name
+	}
+	return column
+}
+
+// isDefault reports whether the naming uses the default names
+func (naming Naming) isDefault() bool {
+	return naming.Schema == "" && naming.TablePrefix == "" && len(naming.Tables) == 0 && len(naming.Columns) == 0
+}
+
+// rewrite replaces the default table and column names in a query written with the
+// default names. Columns are renamed for the tables the query refers to.
+func (naming Naming) rewrite(query string) string {
+	if naming.isDefault() {
+		return query
+	}
+
+	tables := []string{}
+	mapIdentifiers(query, func(ident string) string {
+		for _, table := range tableNames {
+			if ident == table {
+				tables = append(tables, table)
+			}
+		}
+		return ident
+	})
+
+	return mapIdentifiers(query, func(ident string) string {
+		for _, table := range tableNames {
+			if ident == table {
+				return naming.TableName(table)
+			}
+		}
+		for _, table := range tables {
+			if name, ok := naming.Columns[table+"."+ident]; ok {
+				return name
+			}
+		}
+		if name, ok := naming.Columns[ident]; ok {
+			return name
+		}
+		return ident
+	})
+}
+
+// mapIdentifiers replaces the unquoted identifiers of a query with the result of
+// mapping. String literals and quoted identifiers are left unchanged.
+func mapIdentifiers(query string, mapping func(string) string) string {
+	var b strings.Builder
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 2
+		case isIdentifierStart(c):
+			end := i + 1
+			for end < len(query) && (isIdentifierStart(query[end]) || query[end] >= '0' && query[end] <= '9') {
+				end++
+			}
+			b.WriteString(mapping(query[i:end]))
+			i = end
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
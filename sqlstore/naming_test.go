@@ -0,0 +1,125 @@
+package sqlstore
+
+import (
+	"context"
+	"github.com/RangelReale/osin"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNamingRewrite(t *testing.T) {
+	naming := Naming{
+		Schema:      "auth",
+		TablePrefix: "oauth_",
+		Tables:      map[string]string{"consents": "user_consents"},
+		Columns:     map[string]string{"clients.secret": "client_secret", "user_data": "extra"},
+	}
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{
+			"SELECT * FROM clients WHERE tenant_id = ? AND id = ?",
+			"SELECT * FROM auth.oauth_clients WHERE tenant_id = ? AND id = ?",
+		},
+		{
+			"INSERT INTO clients(tenant_id, id, secret, redirect_uri, user_data) VALUES(?, ?, ?, ?, ?)",
+			"INSERT INTO auth.oauth_clients(tenant_id, id, client_secret, redirect_uri, extra) VALUES(?, ?, ?, ?, ?)",
+		},
+		{
+			"DELETE FROM consents WHERE subject = ? AND secret = 'secret'",
+			"DELETE FROM auth.user_consents WHERE subject = ? AND secret = 'secret'",
+		},
+		{
+			"SELECT code FROM authorize_data WHERE json_extract(NULLIF(user_data, ''), ?) = json_extract(?, '$')",
+			"SELECT code FROM auth.oauth_authorize_data WHERE json_extract(NULLIF(extra, ''), ?) = json_extract(?, '$')",
+		},
+	}
+	for _, test := range tests {
+		if query := naming.rewrite(test.query); query != test.expected {
+			t.Errorf("\"%v\": expected %v", query, test.expected)
+		}
+	}
+
+	if query := (Naming{}).rewrite(tests[0].query); query != tests[0].query {
+		t.Errorf("\"%v\": expected %v", query, tests[0].query)
+	}
+}
+
+func TestNaming(t *testing.T) {
+	ctx := context.Background()
+	db := openMemoryDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db, WithNaming(Naming{
+		TablePrefix: "oauth_",
+		Columns:     map[string]string{"clients.secret": "client_secret", "user_data": "extra"},
+	}))
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the configured names exist
+	for _, query := range []string{
+		"SELECT tenant_id, id, client_secret, redirect_uri, extra FROM oauth_clients",
+		"SELECT extra FROM oauth_access_data",
+		"SELECT version FROM oauth_schema_migrations",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Errorf("%s: %v", query, err)
+		}
+	}
+	for _, query := range []string{"SELECT id FROM clients", "SELECT secret FROM oauth_clients"} {
+		if _, err := db.Exec(query); err == nil {
+			t.Errorf("%s should fail", query)
+		}
+	}
+	var count int
+	row := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_oauth_access_data_refresh_token'")
+	if err := row.Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("\"%v\": expected 1", count)
+	}
+
+	client := &osin.DefaultClient{Id: "namedclient", Secret: "secret", RedirectUri: "redirect",
+		UserData: map[string]interface{}{"name": "named"}}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	retClient, err := store.GetClient(client.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(retClient, client) {
+		t.Errorf("\"%v\": expected %v", retClient, client)
+	}
+	keys, err := store.QueryByUserData(ctx, "clients", "$.name", "named")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{client.Id}) {
+		t.Errorf("\"%v\": expected [%v]", keys, client.Id)
+	}
+
+	accessData := &osin.AccessData{AccessToken: "namedaccess", RefreshToken: "namedrefresh", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client, UserData: userData[0]}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadRefresh(accessData.RefreshToken); err != nil {
+		t.Error(err)
+	}
+	if err := store.RemoveClient(client.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); err == nil {
+		t.Error("Access data should be removed with its client")
+	}
+}
@@ -0,0 +1,81 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// OIDCData holds the OpenID Connect values of an authorization request that
+// are needed again when the ID token is issued at the token endpoint. They are
+// stored in the nonce, auth_time, acr, amr and claims columns of authorize_data.
+type OIDCData struct {
+	// Nonce is the nonce parameter of the authorization request
+	Nonce string
+	// AuthTime is the time the end user authenticated, if known
+	AuthTime *time.Time
+	// ACR is the authentication context class reference that was satisfied
+	ACR string
+	// AMR lists the authentication methods that were used
+	AMR []string
+	// Claims is the claims request parameter as raw json
+	Claims json.RawMessage
+}
+
+// oidcColumns holds the scanned OpenID Connect columns of authorize_data
+type oidcColumns struct {
+	nonce    sql.NullString
+	authTime sql.NullTime
+	acr      sql.NullString
+	amr      sql.NullString
+	claims   sql.NullString
+}
+
+// dest returns the scan destinations in column order
+func (c *oidcColumns) dest() []interface{} {
+	return []interface{}{&c.nonce, &c.authTime, &c.acr, &c.amr, &c.claims}
+}
+
+// data returns the scanned values, or nil if none of the columns are set
+func (c *oidcColumns) data() *OIDCData {
+	if !c.nonce.Valid && !c.authTime.Valid && !c.acr.Valid && !c.amr.Valid && !c.claims.Valid {
+		return nil
+	}
+
+	data := &OIDCData{
+		Nonce: c.nonce.String,
+		ACR:   c.acr.String,
+	}
+	if c.authTime.Valid {
+		authTime := c.authTime.Time
+		data.AuthTime = &authTime
+	}
+	if c.amr.String != "" {
+		data.AMR = strings.Split(c.amr.String, " ")
+	}
+	if c.claims.String != "" {
+		data.Claims = json.RawMessage(c.claims.String)
+	}
+	return data
+}
+
+// oidcArgs returns the query arguments for the OpenID Connect columns. Empty
+// values are NULL, and every column is NULL if data is nil.
+func oidcArgs(data *OIDCData) []interface{} {
+	if data == nil {
+		return []interface{}{nil, nil, nil, nil, nil}
+	}
+
+	var authTime, amr, claims interface{}
+	if data.AuthTime != nil {
+		authTime = *data.AuthTime
+	}
+	if len(data.AMR) > 0 {
+		amr = strings.Join(data.AMR, " ")
+	}
+	if len(data.Claims) > 0 {
+		claims = string(data.Claims)
+	}
+	return []interface{}{nullString(data.Nonce), authTime, nullString(data.ACR), amr, claims}
+}
@@ -0,0 +1,118 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/RangelReale/osin"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOIDCAuthorizeData(t *testing.T) {
+	client := &osin.DefaultClient{Id: "oidcclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := testingContext.Store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveClient(client.Id)
+
+	authTime := time.Date(2015, 2, 28, 6, 30, 0, 0, time.UTC)
+	oidc := &OIDCData{
+		Nonce:    "n-0S6_WzA2Mj",
+		AuthTime: &authTime,
+		ACR:      "urn:mace:incommon:iap:silver",
+		AMR:      []string{"pwd", "otp"},
+		Claims:   json.RawMessage(`{"id_token":{"email":{"essential":true}}}`),
+	}
+	authData := &osin.AuthorizeData{Code: "oidccode", ExpiresIn: 100, Scope: "openid email",
+		RedirectUri: "redirect", CreatedAt: time.Now(), Client: client,
+		UserData: &ExtendedUserData{UserData: userData[0], OIDC: oidc}}
+	if err := testingContext.Store.SaveAuthorize(authData); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveAuthorize(authData.Code)
+
+	retAuthData, err := testingContext.Store.LoadAuthorize(authData.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext, ok := retAuthData.UserData.(*ExtendedUserData)
+	if !ok {
+		t.Fatalf("User data has type %T", retAuthData.UserData)
+	}
+	if !reflect.DeepEqual(ext.UserData, userData[0]) {
+		t.Errorf("\"%v\": expected %v", ext.UserData, userData[0])
+	}
+	if ext.OIDC.Nonce != oidc.Nonce || ext.OIDC.ACR != oidc.ACR || !reflect.DeepEqual(ext.OIDC.AMR, oidc.AMR) {
+		t.Errorf("\"%v\": expected %v", ext.OIDC, oidc)
+	}
+	if ext.OIDC.AuthTime == nil || !ext.OIDC.AuthTime.Equal(authTime) {
+		t.Errorf("\"%v\": expected %v", ext.OIDC.AuthTime, authTime)
+	}
+	if string(ext.OIDC.Claims) != string(oidc.Claims) {
+		t.Errorf("\"%s\": expected %s", ext.OIDC.Claims, oidc.Claims)
+	}
+
+	// The OpenID Connect values are not stored in user_data
+	var userDataStr string
+	row := testingContext.DB.DB().QueryRow("SELECT user_data FROM authorize_data WHERE code = ?", authData.Code)
+	if err := row.Scan(&userDataStr); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(userDataStr, oidc.Nonce) {
+		t.Errorf("user_data %s contains the nonce", userDataStr)
+	}
+
+	// Authorize data without OpenID Connect values loads the plain user data
+	authData = &osin.AuthorizeData{Code: "oidccode2", ExpiresIn: 100, Scope: "email",
+		RedirectUri: "redirect", CreatedAt: time.Now(), Client: client, UserData: userData[1]}
+	if err := testingContext.Store.SaveAuthorize(authData); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveAuthorize(authData.Code)
+
+	retAuthData, err = testingContext.Store.LoadAuthorize(authData.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(retAuthData.UserData, userData[1]) {
+		t.Errorf("\"%v\": expected %v", retAuthData.UserData, userData[1])
+	}
+}
+
+func TestOIDCEmptyValues(t *testing.T) {
+	client := &osin.DefaultClient{Id: "oidcclient2", Secret: "secret", RedirectUri: "redirect"}
+	if err := testingContext.Store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveClient(client.Id)
+
+	// Only the auth time is known, so the nonce and acr columns are NULL
+	authTime := time.Date(2015, 2, 28, 6, 30, 0, 0, time.UTC)
+	authData := &osin.AuthorizeData{Code: "oidcemptycode", ExpiresIn: 100, Scope: "openid",
+		RedirectUri: "redirect", CreatedAt: time.Now(), Client: client,
+		UserData: &ExtendedUserData{UserData: userData[0], OIDC: &OIDCData{AuthTime: &authTime}}}
+	if err := testingContext.Store.SaveAuthorize(authData); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveAuthorize(authData.Code)
+
+	var nonce, acr sql.NullString
+	row := testingContext.DB.DB().QueryRow("SELECT nonce, acr FROM authorize_data WHERE code = ?", authData.Code)
+	if err := row.Scan(&nonce, &acr); err != nil {
+		t.Fatal(err)
+	}
+	if nonce.Valid || acr.Valid {
+		t.Errorf("\"%v\", \"%v\": expected NULL", nonce, acr)
+	}
+
+	retAuthData, err := testingContext.Store.LoadAuthorize(authData.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext := retAuthData.UserData.(*ExtendedUserData)
+	if ext.OIDC == nil || ext.OIDC.Nonce != "" || ext.OIDC.ACR != "" || !ext.OIDC.AuthTime.Equal(authTime) {
+		t.Errorf("\"%v\": expected only the auth time", ext.OIDC)
+	}
+}
@@ -0,0 +1,142 @@
+package sqlstore
+
+import (
+	"context"
+	"time"
+)
+
+// Defaults of a Relay
+const (
+	DefaultPollInterval = time.Second
+	DefaultBatchSize    = 100
+)
+
+// WithOutbox writes a lifecycle event to the outbox_events table for every change
+// that WithAuditEvents describes, in the same transaction as the change it records.
+// It does not require WithAuditEvents. A Relay delivers the events.
+func WithOutbox() Option {
+	return func(store *SQLStorage) {
+		store.outbox = true
+	}
+}
+
+// insertOutboxEvent writes an event to the outbox in the transaction of a write
+func (store *SQLStorage) insertOutboxEvent(ctx context.Context, tx Querier, event *AuditEvent) error {
+	_, err := store.exec(ctx, tx, store.rebind(`
+		INSERT INTO outbox_events(tenant_id, id, event_type, client_id, subject, token_fingerprint, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+		`), store.tenantID, event.ID, string(event.Type), event.ClientID, nullString(event.Subject),
+		nullString(event.TokenFingerprint), event.CreatedAt)
+	return err
+}
+
+// Sink receives the events delivered by a Relay
+type Sink interface {
+	// Deliver delivers an event. An event is removed from the outbox once Deliver
+	// returns nil, so events may be delivered again if the relay stops before.
+	// Sinks can use the event ID to detect duplicates.
+	Deliver(ctx context.Context, event *AuditEvent) error
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(ctx context.Context, event *AuditEvent) error
+
+func (f SinkFunc) Deliver(ctx context.Context, event *AuditEvent) error {
+	return f(ctx, event)
+}
+
+// Relay delivers the events in the outbox of the tenant of a SQLStorage to a Sink
+// at least once, oldest first. An event that fails to be delivered blocks the
+// events after it until it is delivered. Several relays of the same tenant do not
+// coordinate and deliver events more than once.
+type Relay struct {
+	store        *SQLStorage
+	sink         Sink
+	pollInterval time.Duration
+	batchSize    int
+}
+
+// RelayOption configures a Relay
+type RelayOption func(*Relay)
+
+// WithPollInterval sets how often Run polls the outbox (DefaultPollInterval by default)
+func WithPollInterval(interval time.Duration) RelayOption {
+	return func(relay *Relay) {
+		relay.pollInterval = interval
+	}
+}
+
+// WithBatchSize sets how many events a poll delivers at most (DefaultBatchSize by default)
+func WithBatchSize(size int) RelayOption {
+	return func(relay *Relay) {
+		relay.batchSize = size
+	}
+}
+
+// NewRelay returns a Relay that delivers the outbox of store to sink
+func NewRelay(store *SQLStorage, sink Sink, opts ...RelayOption) *Relay {
+	relay := &Relay{
+		store:        store,
+		sink:         sink,
+		pollInterval: DefaultPollInterval,
+		batchSize:    DefaultBatchSize,
+	}
+	for _, opt := range opts {
+		opt(relay)
+	}
+	return relay
+}
+
+// Run polls the outbox until ctx is done. Failed polls are retried at the next
+// poll. It returns the error of ctx.
+func (relay *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(relay.pollInterval)
+	defer ticker.Stop()
+	for {
+		// Keep polling without waiting while there are full batches
+		for {
+			delivered, err := relay.Poll(ctx)
+			if err != nil || delivered < relay.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll delivers a batch of the oldest events in the outbox and returns the number
+// of delivered events. It stops at the first event that fails to be delivered.
+func (relay *Relay) Poll(ctx context.Context) (_ int, err error) {
+	store := relay.store
+	ctx, op := store.begin(ctx, "PollOutbox")
+	defer op.end(&err)
+
+	rows, err := store.query(ctx, store.authDB, store.rebind(`
+		SELECT id, event_type, client_id, subject, token_fingerprint, created_at FROM outbox_events
+		WHERE tenant_id = ? ORDER BY created_at, id LIMIT ?
+		`), store.tenantID, relay.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	events, err := scanEvents(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, event := range events {
+		if err := relay.sink.Deliver(ctx, event); err != nil {
+			return i, err
+		}
+		_, err := store.exec(ctx, store.authDB, store.rebind(
+			"DELETE FROM outbox_events WHERE tenant_id = ? AND id = ?"), store.tenantID, event.ID)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
@@ -0,0 +1,161 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/RangelReale/osin"
+	"testing"
+	"time"
+)
+
+// channelSink delivers events to a channel
+type channelSink chan *AuditEvent
+
+func (sink channelSink) Deliver(ctx context.Context, event *AuditEvent) error {
+	select {
+	case sink <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func receiveEvents(t *testing.T, sink channelSink, n int) []*AuditEvent {
+	events := []*AuditEvent{}
+	for len(events) < n {
+		select {
+		case event := <-sink:
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Received %d events, expected %d", len(events), n)
+		}
+	}
+	return events
+}
+
+func TestOutboxRelay(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db, WithOutbox())
+	client := &osin.DefaultClient{Id: "outboxclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "outboxaccess", ExpiresIn: 100, CreatedAt: time.Now(), Client: client,
+		UserData: &ExtendedUserData{Subject: "alice"}}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveAccess(accessData.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	// The relay crashes after the sink received the first event but before the
+	// event was removed from the outbox
+	sink := make(channelSink, 10)
+	crashCtx, crash := context.WithCancel(ctx)
+	crashing := NewRelay(store, SinkFunc(func(ctx context.Context, event *AuditEvent) error {
+		sink <- event
+		crash()
+		return nil
+	}))
+	if delivered, err := crashing.Poll(crashCtx); err == nil || delivered != 0 {
+		t.Fatalf("%d, \"%v\": expected a crash", delivered, err)
+	}
+	crashed := receiveEvents(t, sink, 1)[0]
+
+	// A new relay delivers the event again, followed by the rest
+	relay := NewRelay(store, sink, WithBatchSize(1))
+	for i := 0; i < 2; i++ {
+		if delivered, err := relay.Poll(ctx); err != nil || delivered != 1 {
+			t.Fatalf("%d, \"%v\": expected 1 delivered event", delivered, err)
+		}
+	}
+	events := receiveEvents(t, sink, 2)
+	if events[0].ID != crashed.ID || events[0].Type != AuditAccessTokenIssued {
+		t.Errorf("Unexpected event %+v, expected %+v", events[0], crashed)
+	}
+	if events[1].Type != AuditAccessTokenRemoved || events[1].Subject != "alice" ||
+		events[1].TokenFingerprint != TokenFingerprint(accessData.AccessToken) || events[1].ClientID != client.Id {
+		t.Errorf("Unexpected event %+v", events[1])
+	}
+	if delivered, err := relay.Poll(ctx); err != nil || delivered != 0 {
+		t.Errorf("%d, \"%v\": expected an empty outbox", delivered, err)
+	}
+
+	// A failing sink keeps the event in the outbox
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	failing := NewRelay(store, SinkFunc(func(context.Context, *AuditEvent) error {
+		return errors.New("unavailable")
+	}))
+	if _, err := failing.Poll(ctx); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	// Run delivers the pending and new events
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- NewRelay(store, sink, WithPollInterval(10*time.Millisecond)).Run(runCtx)
+	}()
+	if err := store.RemoveAccess(accessData.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	events = receiveEvents(t, sink, 2)
+	if events[0].Type != AuditAccessTokenIssued || events[1].Type != AuditAccessTokenRemoved {
+		t.Errorf("Unexpected events %+v %+v", events[0], events[1])
+	}
+	stop()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("\"%v\": expected %v", err, context.Canceled)
+	}
+}
+
+func TestOutboxAtomicity(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db, WithOutbox())
+	client := &osin.DefaultClient{Id: "outboxclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "outboxaccess", ExpiresIn: 100, CreatedAt: time.Now(), Client: client}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed write leaves no event
+	if err := store.SaveAccess(accessData); err == nil {
+		t.Fatal("Expected an error")
+	}
+	sink := make(channelSink, 10)
+	if delivered, err := NewRelay(store, sink).Poll(ctx); err != nil || delivered != 1 {
+		t.Fatalf("%d, \"%v\": expected 1 delivered event", delivered, err)
+	}
+
+	// The write is rolled back if the event can not be written, as if the process
+	// crashed in the middle of the transaction
+	if _, err := db.Exec("DROP TABLE outbox_events"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveAccess(accessData.AccessToken); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); err != nil {
+		t.Errorf("\"%v\": expected the access data to be kept", err)
+	}
+	accessData.AccessToken = "outboxaccess2"
+	if err := store.SaveAccess(accessData); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+}
@@ -0,0 +1,89 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/url"
+	"time"
+)
+
+// PushedRequest holds the authorization request parameters a client pushed to
+// the authorization server (RFC 9126). It is referenced by a one-time request_uri.
+type PushedRequest struct {
+	RequestURI string
+	ClientID   string
+	Parameters url.Values
+	ExpiresIn  int32
+	CreatedAt  time.Time
+}
+
+// IsExpiredAt is true if the request_uri is expired at t
+func (pr *PushedRequest) IsExpiredAt(t time.Time) bool {
+	return pr.CreatedAt.Add(time.Duration(pr.ExpiresIn) * time.Second).Before(t)
+}
+
+// SavePushedRequest saves pushed authorization request parameters
+func (store *SQLStorage) SavePushedRequest(ctx context.Context, pr *PushedRequest) (err error) {
+	ctx, op := store.begin(ctx, "SavePushedRequest")
+	defer op.end(&err)
+	params, err := json.Marshal(pr.Parameters)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.exec(ctx, store.authDB, store.rebind(`
+		INSERT INTO par_requests(tenant_id, request_uri, client_id, parameters, expires_in, created_at)
+		VALUES(?, ?, ?, ?, ?, ?)
+		`), store.tenantID, pr.RequestURI, pr.ClientID, string(params), pr.ExpiresIn, pr.CreatedAt)
+	return err
+}
+
+// ConsumePushedRequest loads and removes the pushed request for requestURI, so that
+// it can only be used once. It returns sql.ErrNoRows if the request_uri does not
+// exist, was already used, has expired or belongs to another client.
+func (store *SQLStorage) ConsumePushedRequest(ctx context.Context, requestURI, clientID string) (_ *PushedRequest, err error) {
+	ctx, op := store.begin(ctx, "ConsumePushedRequest")
+	defer op.end(&err)
+	var (
+		pr     PushedRequest
+		params string
+	)
+
+	tx, err := store.authDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	where := "tenant_id = ? AND request_uri = ? AND client_id = ?"
+	args := []interface{}{store.tenantID, requestURI, clientID}
+
+	row := store.queryRow(ctx, tx, store.rebind(`
+		SELECT request_uri, client_id, parameters, expires_in, created_at
+		FROM par_requests WHERE `+where), args...)
+	if err := row.Scan(&pr.RequestURI, &pr.ClientID, &params, &pr.ExpiresIn, &pr.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	// Only the caller whose delete removed the row consumed the request
+	result, err := store.exec(ctx, tx, store.rebind("DELETE FROM par_requests WHERE "+where), args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireRowsAffected(result); err != nil {
+		return nil, err
+	}
+
+	// Expired requests are removed as well, but not returned
+	if pr.IsExpiredAt(store.now()) {
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
+	if err := json.Unmarshal([]byte(params), &pr.Parameters); err != nil {
+		return nil, err
+	}
+	return &pr, tx.Commit()
+}
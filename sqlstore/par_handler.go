@@ -0,0 +1,110 @@
+package sqlstore
+
+import (
+	"github.com/RangelReale/osin"
+	"net/http"
+	"net/url"
+)
+
+// requestURIPrefix is the URN prefix of request_uri values (RFC 9126 section 2.2)
+const requestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
+// PushedAuthorization serves the pushed authorization request endpoint (RFC 9126)
+// and resolves request_uri parameters at the authorization endpoint
+type PushedAuthorization struct {
+	Server *osin.Server
+	Store  *SQLStorage
+
+	// ExpiresIn is the lifetime of request_uri values in seconds
+	ExpiresIn int32
+}
+
+// NewPushedAuthorization creates a pushed authorization endpoint with a 60 second
+// request_uri lifetime
+func NewPushedAuthorization(server *osin.Server, store *SQLStorage) *PushedAuthorization {
+	return &PushedAuthorization{
+		Server:    server,
+		Store:     store,
+		ExpiresIn: 60,
+	}
+}
+
+// HandlePushedAuthorization handles a pushed authorization request from an
+// authenticated client and responds with a new request_uri
+func (par *PushedAuthorization) HandlePushedAuthorization(w http.ResponseWriter, r *http.Request) {
+	resp := par.Server.NewResponse()
+	defer resp.Close()
+	defer osin.OutputJSON(resp, w, r)
+
+	if r.Method != "POST" {
+		resp.SetError(osin.E_INVALID_REQUEST, "")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		resp.SetError(osin.E_INVALID_REQUEST, "")
+		resp.InternalError = err
+		return
+	}
+	client := authenticateClient(resp, r)
+	if client == nil {
+		return
+	}
+
+	params := url.Values{}
+	for key, values := range r.PostForm {
+		if key != "client_secret" {
+			params[key] = values
+		}
+	}
+	// A pushed request can not reference another one
+	if params.Get("request_uri") != "" {
+		resp.SetError(osin.E_INVALID_REQUEST, "request_uri is not allowed in a pushed authorization request")
+		return
+	}
+	params.Set("client_id", client.GetId())
+
+	token, err := randomToken(32)
+	if err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return
+	}
+
+	pr := &PushedRequest{
+		RequestURI: requestURIPrefix + token,
+		ClientID:   client.GetId(),
+		Parameters: params,
+		ExpiresIn:  par.ExpiresIn,
+		CreatedAt:  par.Server.Now(),
+	}
+	if err := par.Store.SavePushedRequest(r.Context(), pr); err != nil {
+		resp.SetError(osin.E_SERVER_ERROR, "")
+		resp.InternalError = err
+		return
+	}
+
+	resp.StatusCode = http.StatusCreated
+	resp.Output["request_uri"] = pr.RequestURI
+	resp.Output["expires_in"] = pr.ExpiresIn
+}
+
+// ResolveAuthorizeRequest replaces the parameters of an authorization request that
+// has a request_uri with the pushed parameters, consuming the request_uri. It should
+// be called before osin.Server.HandleAuthorizeRequest. Requests without a request_uri
+// are left unchanged.
+func (par *PushedAuthorization) ResolveAuthorizeRequest(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	requestURI := r.Form.Get("request_uri")
+	if requestURI == "" {
+		return nil
+	}
+
+	pr, err := par.Store.ConsumePushedRequest(r.Context(), requestURI, r.Form.Get("client_id"))
+	if err != nil {
+		return err
+	}
+	r.Form = pr.Parameters
+	return nil
+}
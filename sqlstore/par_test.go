@@ -0,0 +1,114 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/RangelReale/osin"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushedAuthorization(t *testing.T) {
+	client := &osin.DefaultClient{Id: "parclient", Secret: "secret", RedirectUri: "http://localhost:14001/appauth"}
+	if err := testingContext.Store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveClient(client.Id)
+
+	server := osin.NewServer(osin.NewServerConfig(), testingContext.Store)
+	par := NewPushedAuthorization(server, testingContext.Store)
+
+	params := url.Values{
+		"response_type": {"code"},
+		"redirect_uri":  {client.RedirectUri},
+		"scope":         {"payments"},
+		"state":         {"parstate"},
+	}
+	output := postForm(t, par.HandlePushedAuthorization, params, client.Id, "wrong")
+	if output["error"] != osin.E_INVALID_CLIENT {
+		t.Errorf("\"%v\": expected %v", output["error"], osin.E_INVALID_CLIENT)
+	}
+
+	output = postForm(t, par.HandlePushedAuthorization, params, client.Id, client.Secret)
+	requestURI, _ := output["request_uri"].(string)
+	if !strings.HasPrefix(requestURI, requestURIPrefix) {
+		t.Fatalf("Unexpected pushed authorization response %v", output)
+	}
+
+	// The authorization request only carries the client id and request_uri
+	authorizeURL := url.URL{Path: "/authorize", RawQuery: url.Values{
+		"client_id":   {client.Id},
+		"request_uri": {requestURI},
+	}.Encode()}
+	req, err := http.NewRequest("GET", authorizeURL.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := par.ResolveAuthorizeRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := server.NewResponse()
+	defer resp.Close()
+	ar := server.HandleAuthorizeRequest(resp, req)
+	if ar == nil {
+		t.Fatalf("Unexpected authorize error: %v, %v", resp.ErrorId, resp.InternalError)
+	}
+	if ar.Scope != "payments" || ar.State != "parstate" {
+		t.Errorf("Authorize request %v does not have the pushed parameters", ar)
+	}
+
+	// The request_uri can only be used once
+	req, _ = http.NewRequest("GET", authorizeURL.String(), nil)
+	if err := par.ResolveAuthorizeRequest(req); err == nil {
+		t.Error("Error should be thrown")
+	}
+}
+
+func TestPushedRequestExpired(t *testing.T) {
+	ctx := context.Background()
+	pr := &PushedRequest{RequestURI: requestURIPrefix + "expired", ClientID: "parclient",
+		Parameters: url.Values{"scope": {"payments"}}, ExpiresIn: 60, CreatedAt: time.Now().Add(-time.Hour)}
+	if err := testingContext.Store.SavePushedRequest(ctx, pr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testingContext.Store.ConsumePushedRequest(ctx, pr.RequestURI, pr.ClientID); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestConcurrentPushedRequestConsumption(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db)
+	pr := &PushedRequest{RequestURI: requestURIPrefix + "concurrent", ClientID: "parclient",
+		Parameters: url.Values{"scope": {"payments"}}, ExpiresIn: 60, CreatedAt: time.Now()}
+	if err := store.SavePushedRequest(ctx, pr); err != nil {
+		t.Fatal(err)
+	}
+
+	// Exactly one caller consumes the request, the others find it used
+	results := make(chan error, 10)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			_, err := store.ConsumePushedRequest(ctx, pr.RequestURI, pr.ClientID)
+			results <- err
+		}()
+	}
+	consumed := 0
+	for i := 0; i < cap(results); i++ {
+		if err := <-results; err == nil {
+			consumed++
+		} else if err != sql.ErrNoRows {
+			t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+		}
+	}
+	if consumed != 1 {
+		t.Errorf("%d callers consumed the request, expected 1", consumed)
+	}
+}
@@ -0,0 +1,103 @@
+package sqlstore
+
+import (
+	"github.com/RangelReale/osin"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestPKCE(t *testing.T) {
+	client := &osin.DefaultClient{Id: "pkceclient", Secret: "", RedirectUri: "http://localhost:14001/appauth"}
+	if err := testingContext.Store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	defer testingContext.Store.RemoveClient(client.Id)
+
+	config := osin.NewServerConfig()
+	config.AllowedAccessTypes = osin.AllowedAccessType{osin.AUTHORIZATION_CODE}
+	config.RequirePKCEForPublicClients = true
+	server := osin.NewServer(config, testingContext.Store)
+
+	tests := []struct {
+		name            string
+		challenge       string
+		challengeMethod string
+		verifier        string
+		expectedError   string
+	}{
+		{"good, plain", "12345678901234567890123456789012345678901234567890", "",
+			"12345678901234567890123456789012345678901234567890", ""},
+		{"bad, plain", "12345678901234567890123456789012345678901234567890", "plain",
+			"xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx", "invalid_grant"},
+		{"good, S256", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", "S256",
+			"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", ""},
+		{"bad, S256", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", "S256",
+			"xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx", "invalid_grant"},
+	}
+
+	for _, test := range tests {
+		// Request an authorization code with the code challenge
+		authorizeURL := url.URL{Path: "/authorize", RawQuery: url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.Id},
+			"redirect_uri":          {client.RedirectUri},
+			"state":                 {"state"},
+			"code_challenge":        {test.challenge},
+			"code_challenge_method": {test.challengeMethod},
+		}.Encode()}
+		req, err := http.NewRequest("GET", authorizeURL.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := server.NewResponse()
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+		if resp.IsError {
+			t.Errorf("%s: unexpected authorize error: %v, %v", test.name, resp.ErrorId, resp.InternalError)
+			resp.Close()
+			continue
+		}
+		code, _ := resp.Output["code"].(string)
+		resp.Close()
+
+		// Exchange the code with the code verifier
+		req, err = http.NewRequest("POST", "/token", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(client.Id, client.Secret)
+		req.Form = url.Values{
+			"grant_type":    {string(osin.AUTHORIZATION_CODE)},
+			"code":          {code},
+			"redirect_uri":  {client.RedirectUri},
+			"code_verifier": {test.verifier},
+		}
+		req.PostForm = url.Values{}
+
+		resp = server.NewResponse()
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+
+		if test.expectedError != "" {
+			if !resp.IsError || resp.ErrorId != test.expectedError {
+				t.Errorf("%s: \"%v\": expected %v", test.name, resp.ErrorId, test.expectedError)
+			}
+			testingContext.Store.RemoveAuthorize(code)
+		} else if resp.IsError {
+			t.Errorf("%s: unexpected access error: %v, %v", test.name, resp.ErrorId, resp.InternalError)
+		} else {
+			accessToken, _ := resp.Output["access_token"].(string)
+			if accessToken == "" {
+				t.Errorf("%s: no access token in response %v", test.name, resp.Output)
+			}
+			testingContext.Store.RemoveAccess(accessToken)
+		}
+		resp.Close()
+	}
+}
@@ -0,0 +1,118 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultReadYourWritesWindow is how long lookups of a written key are sent to the
+// writer by default
+const DefaultReadYourWritesWindow = 5 * time.Second
+
+// Key prefixes of the keys recorded by recordWrite
+const (
+	clientWriteKey = "client:"
+	codeWriteKey   = "code:"
+	tokenWriteKey  = "token:"
+)
+
+// replicaSet routes reads to read replicas and remembers recently written keys
+type replicaSet struct {
+	readers []*sql.DB
+	next    uint64
+	window  time.Duration
+
+	mu         sync.Mutex
+	written    map[string]time.Time
+	pruneAfter int
+}
+
+// WithReadYourWritesWindow sets how long lookups of a client id, code or token that
+// was written by the storage are sent to the writer instead of a read replica, which
+// may not have replicated the write yet (DefaultReadYourWritesWindow by default).
+func WithReadYourWritesWindow(window time.Duration) Option {
+	return func(store *SQLStorage) {
+		store.readYourWritesWindow = window
+	}
+}
+
+// NewReplicatedSQLStorage returns a SQLStorage that writes to writer and sends
+// GetClient and the Load methods of osin.Storage to the readers in turn. All other
+// queries, including the extension APIs and Migrate, use the writer. The readers
+// replicate the token database, so GetClient reads the client database if it is
+// set with WithClientDB.
+func NewReplicatedSQLStorage(writer *sql.DB, readers []*sql.DB, opts ...Option) *SQLStorage {
+	store := NewSQLStorage(writer, opts...)
+	if len(readers) > 0 {
+		store.replicas = &replicaSet{
+			readers:    readers,
+			window:     store.readYourWritesWindow,
+			written:    map[string]time.Time{},
+			pruneAfter: 64,
+		}
+	}
+	return store
+}
+
+// readDB returns the database to look key up in
+func (store *SQLStorage) readDB(key string) *sql.DB {
+	replicas := store.replicas
+	if replicas == nil || replicas.recentlyWritten(key) {
+		return store.authDB
+	}
+	n := atomic.AddUint64(&replicas.next, 1)
+	return replicas.readers[n%uint64(len(replicas.readers))]
+}
+
+// clientReadDB returns the database to look up the client id in. Read replicas
+// are replicas of the token database, so they are only used for clients if the
+// clients are stored in the token database.
+func (store *SQLStorage) clientReadDB(id string) *sql.DB {
+	if store.clientDB != store.authDB {
+		return store.clientDB
+	}
+	return store.readDB(clientWriteKey + id)
+}
+
+// recordWrite remembers that keys are about to be written, so that they are
+// read from the writer for the read-your-writes window
+func (store *SQLStorage) recordWrite(keys ...string) {
+	if store.replicas != nil {
+		store.replicas.record(keys)
+	}
+}
+
+// recentlyWritten reports whether key was written within the window
+func (replicas *replicaSet) recentlyWritten(key string) bool {
+	replicas.mu.Lock()
+	defer replicas.mu.Unlock()
+
+	writtenAt, ok := replicas.written[key]
+	return ok && time.Since(writtenAt) < replicas.window
+}
+
+func (replicas *replicaSet) record(keys []string) {
+	if replicas.window <= 0 {
+		return
+	}
+
+	replicas.mu.Lock()
+	defer replicas.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		replicas.written[key] = now
+	}
+
+	// Prune expired keys once the map has doubled since the last pruning
+	if len(replicas.written) >= replicas.pruneAfter {
+		for key, writtenAt := range replicas.written {
+			if now.Sub(writtenAt) >= replicas.window {
+				delete(replicas.written, key)
+			}
+		}
+		replicas.pruneAfter = 2*len(replicas.written) + 64
+	}
+}
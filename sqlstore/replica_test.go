@@ -0,0 +1,93 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"github.com/RangelReale/osin"
+	"testing"
+	"time"
+)
+
+func TestReplicatedSQLStorage(t *testing.T) {
+	writer := openMigratedDB(t)
+	defer writer.Close()
+	// The replica never receives the writes, as if replication lagged forever
+	reader := openMigratedDB(t)
+	defer reader.Close()
+
+	store := NewReplicatedSQLStorage(writer, []*sql.DB{reader})
+	client := &osin.DefaultClient{Id: "replicaclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "replicaaccess", RefreshToken: "replicarefresh",
+		ExpiresIn: 100, CreatedAt: time.Now(), Client: client}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fresh writes are read from the writer
+	if _, err := store.LoadAccess(accessData.AccessToken); err != nil {
+		t.Error(err)
+	}
+	if _, err := store.LoadRefresh(accessData.RefreshToken); err != nil {
+		t.Error(err)
+	}
+
+	// Other keys are read from the replica
+	if _, err := reader.Exec("INSERT INTO clients(id, secret, redirect_uri) VALUES('replicaonly', 'secret', 'redirect')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetClient("replicaonly"); err != nil {
+		t.Error(err)
+	}
+
+	// Once the window has passed the replica is used for the written keys too
+	store = NewReplicatedSQLStorage(writer, []*sql.DB{reader}, WithReadYourWritesWindow(0))
+	if _, err := store.LoadAccess(accessData.AccessToken); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestReadYourWritesRemove(t *testing.T) {
+	writer := openMigratedDB(t)
+	defer writer.Close()
+	reader := openMigratedDB(t)
+	defer reader.Close()
+
+	// The replica has replicated the client and token, but not their removal
+	seed := NewSQLStorage(reader)
+	client := &osin.DefaultClient{Id: "replicaclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := seed.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "replicaaccess", RefreshToken: "replicarefresh",
+		ExpiresIn: 100, CreatedAt: time.Now(), Client: client}
+	if err := seed.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewSQLStorage(writer).SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewSQLStorage(writer).SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewReplicatedSQLStorage(writer, []*sql.DB{reader})
+	if _, err := store.LoadAccess(accessData.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the refresh token also sends lookups of its access token to the writer
+	if err := store.RemoveRefresh(accessData.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+	if err := store.RemoveClient(client.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetClient(client.Id); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+}
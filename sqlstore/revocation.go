@@ -0,0 +1,139 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Reasons of revocations made by the storage
+const (
+	// RevokedReasonRemoved is the reason of codes and tokens removed by RemoveAuthorize,
+	// RemoveAccess and RemoveRefresh with WithSoftDelete
+	RevokedReasonRemoved = "removed"
+	// RevokedReasonSubjectTokenRevoked is the reason of tokens removed by RevokeSubjectToken
+	RevokedReasonSubjectTokenRevoked = "subject_token_revoked"
+	// RevokedReasonConsentRevoked is the reason of tokens removed by RevokeConsent
+	RevokedReasonConsentRevoked = "consent_revoked"
+)
+
+// ErrRevoked matches the errors of loads of revoked codes and tokens if
+// WithRevokedErrors is used
+var ErrRevoked = errors.New("sqlstore: revoked")
+
+// RevokedError is returned by LoadAuthorize, LoadAccess and LoadRefresh for revoked
+// codes and tokens if WithRevokedErrors is used. errors.Is matches it with both
+// ErrRevoked and sql.ErrNoRows, so it is still treated as not found.
+type RevokedError struct {
+	RevokedAt time.Time
+	Reason    string
+}
+
+func (err *RevokedError) Error() string {
+	return "sqlstore: revoked at " + err.RevokedAt.Format(time.RFC3339) + ": " + err.Reason
+}
+
+func (err *RevokedError) Is(target error) bool {
+	return target == ErrRevoked || target == sql.ErrNoRows
+}
+
+// softDeleteTables are the tables whose rows can be revoked instead of removed.
+// Revoked rows are kept as tombstones with revoked_at and revoked_reason set.
+var softDeleteTables = map[string]bool{"authorize_data": true, "access_data": true}
+
+// WithSoftDelete revokes authorize and access data instead of removing it.
+// RemoveAuthorize, RemoveAccess, RemoveRefresh, RevokeSubjectToken and RevokeConsent
+// set revoked_at and a reason, and the rows are kept until PurgeRevoked removes them.
+// Revoked codes and tokens are not found by the Load methods, TokenLineage and
+// QueryByUserData.
+func WithSoftDelete() Option {
+	return func(store *SQLStorage) {
+		store.softDelete = true
+	}
+}
+
+// WithRevokedErrors makes LoadAuthorize, LoadAccess and LoadRefresh return a
+// *RevokedError instead of sql.ErrNoRows for revoked codes and tokens, for example
+// to detect the reuse of a revoked refresh token
+func WithRevokedErrors() Option {
+	return func(store *SQLStorage) {
+		store.revokedErrors = true
+	}
+}
+
+// removeRows removes the rows of table matching where, or revokes them with reason
+// if soft is set
+func (store *SQLStorage) removeRows(ctx context.Context, tx Querier, table, where string, args []interface{},
+	soft bool, reason string) (sql.Result, error) {
+	args = append([]interface{}{store.tenantID}, args...)
+	if !soft {
+		return store.exec(ctx, tx, store.rebind("DELETE FROM "+table+" WHERE tenant_id = ? AND "+where), args...)
+	}
+	args = append([]interface{}{store.now(), reason}, args...)
+	return store.exec(ctx, tx, store.rebind("UPDATE "+table+" SET revoked_at = ?, revoked_reason = ? "+
+		"WHERE tenant_id = ? AND "+where+" AND revoked_at IS NULL"), args...)
+}
+
+// notRevoked returns the condition that excludes revoked rows of table, if it has any
+func notRevoked(table string) string {
+	if softDeleteTables[table] {
+		return " AND revoked_at IS NULL"
+	}
+	return ""
+}
+
+// revokedError returns the error of a load of a revoked row
+func (store *SQLStorage) revokedError(revokedAt time.Time, reason string) error {
+	if store.revokedErrors {
+		return &RevokedError{RevokedAt: revokedAt, Reason: reason}
+	}
+	return sql.ErrNoRows
+}
+
+// RevokeAuthorize revokes an authorization code with a reason, keeping it as a
+// tombstone even without WithSoftDelete. It runs the RemoveAuthorize hooks.
+func (store *SQLStorage) RevokeAuthorize(ctx context.Context, code, reason string) (err error) {
+	ctx, op := store.begin(ctx, "RevokeAuthorize")
+	defer op.end(&err)
+	return store.removeAuthorize(ctx, code, true, reason)
+}
+
+// RevokeAccess revokes an access token with a reason, keeping it as a tombstone
+// even without WithSoftDelete. It runs the RemoveAccess hooks.
+func (store *SQLStorage) RevokeAccess(ctx context.Context, token, reason string) (err error) {
+	ctx, op := store.begin(ctx, "RevokeAccess")
+	defer op.end(&err)
+	return store.removeAccess(ctx, token, true, reason)
+}
+
+// RevokeRefresh revokes the access data of a refresh token with a reason, keeping
+// it as a tombstone even without WithSoftDelete. It runs the RemoveRefresh hooks.
+func (store *SQLStorage) RevokeRefresh(ctx context.Context, token, reason string) (err error) {
+	ctx, op := store.begin(ctx, "RevokeRefresh")
+	defer op.end(&err)
+	return store.removeRefresh(ctx, token, true, reason)
+}
+
+// PurgeRevoked removes the authorize and access data of the tenant that was revoked
+// at least retention ago and returns the number of removed rows
+func (store *SQLStorage) PurgeRevoked(ctx context.Context, retention time.Duration) (_ int64, err error) {
+	ctx, op := store.begin(ctx, "PurgeRevoked")
+	defer op.end(&err)
+
+	var total int64
+	for _, table := range []string{"authorize_data", "access_data"} {
+		result, err := store.exec(ctx, store.authDB, store.rebind(
+			"DELETE FROM "+table+" WHERE tenant_id = ? AND revoked_at <= ?"), store.tenantID, store.now().Add(-retention))
+		if err != nil {
+			return total, err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		store.purged(table, rows)
+		total += rows
+	}
+	return total, nil
+}
@@ -0,0 +1,118 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/RangelReale/osin"
+	"testing"
+	"time"
+)
+
+func TestSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db, WithSoftDelete(), WithRevokedErrors(), WithAuditEvents())
+	client := &osin.DefaultClient{Id: "softclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	authData := &osin.AuthorizeData{Code: "softcode", ExpiresIn: 100, RedirectUri: "redirect",
+		CreatedAt: time.Now(), Client: client}
+	if err := store.SaveAuthorize(authData); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "softaccess", RefreshToken: "softrefresh", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client, UserData: map[string]interface{}{"user": "alice"}}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+
+	// A refreshed token is revoked, so its reuse is detected
+	if err := store.RemoveRefresh(accessData.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.LoadRefresh(accessData.RefreshToken)
+	var revoked *RevokedError
+	if !errors.Is(err, ErrRevoked) || !errors.Is(err, sql.ErrNoRows) || !errors.As(err, &revoked) {
+		t.Fatalf("\"%v\": expected %v", err, ErrRevoked)
+	}
+	if revoked.Reason != RevokedReasonRemoved || revoked.RevokedAt.IsZero() {
+		t.Errorf("Unexpected revocation %+v", revoked)
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); !errors.Is(err, ErrRevoked) {
+		t.Errorf("\"%v\": expected %v", err, ErrRevoked)
+	}
+	if keys, err := store.QueryByUserData(ctx, "access_data", "$.user", "alice"); err != nil || len(keys) != 0 {
+		t.Errorf("\"%v\", %v: expected no tokens", keys, err)
+	}
+
+	// Removing a revoked token again records no event and keeps the first reason
+	if err := store.RemoveAccess(accessData.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	events, err := store.AuditEvents(ctx, AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[2].Type != AuditRefreshTokenRemoved {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	if err := store.RevokeAuthorize(ctx, authData.Code, "user_denied"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadAuthorize(authData.Code); !errors.As(err, &revoked) || revoked.Reason != "user_denied" {
+		t.Errorf("\"%v\": expected revoked with user_denied", err)
+	}
+
+	// Without WithRevokedErrors revoked tokens are not found
+	if _, err := NewSQLStorage(db).LoadAccess(accessData.AccessToken); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+
+	// Tombstones are kept until the retention has passed
+	if rows, err := store.PurgeRevoked(ctx, time.Hour); err != nil || rows != 0 {
+		t.Errorf("%v, %v: expected 0 purged rows", rows, err)
+	}
+	if rows, err := store.PurgeRevoked(ctx, 0); err != nil || rows != 2 {
+		t.Errorf("%v, %v: expected 2 purged rows", rows, err)
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); errors.Is(err, ErrRevoked) || err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestRevokeWithoutSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db, WithRevokedErrors())
+	client := &osin.DefaultClient{Id: "hardclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"hardaccess", "revokedaccess"} {
+		accessData := &osin.AccessData{AccessToken: token, ExpiresIn: 100, CreatedAt: time.Now(), Client: client}
+		if err := store.SaveAccess(accessData); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Removals delete the rows, while explicit revocations keep tombstones
+	if err := store.RemoveAccess("hardaccess"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeAccess(ctx, "revokedaccess", "compromised"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadAccess("hardaccess"); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+	if _, err := store.LoadAccess("revokedaccess"); !errors.Is(err, ErrRevoked) {
+		t.Errorf("\"%v\": expected %v", err, ErrRevoked)
+	}
+}
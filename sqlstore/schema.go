@@ -0,0 +1,249 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// schemaTable is a table with the columns the storage reads and writes
+type schemaTable struct {
+	name    string
+	columns []column
+}
+
+// schemaTables is the schema created by the migrations
+var schemaTables = []schemaTable{
+	{"clients", []column{
+		{"tenant_id", tenantColumn},
+		{"id", keyColumn},
+		{"secret", textColumn},
+		{"redirect_uri", textColumn},
+		{"user_data", jsonColumn},
+	}},
+	{"authorize_data", []column{
+		{"tenant_id", tenantColumn},
+		{"code", keyColumn},
+		{"expires_in", intColumn},
+		{"scope", textColumn},
+		{"redirect_uri", textColumn},
+		{"state", textColumn},
+		{"created_at", timeColumn},
+		{"user_data", jsonColumn},
+		{"client_id", keyColumn},
+		{"code_challenge", textColumn},
+		{"code_challenge_method", textColumn},
+		{"nonce", textColumn},
+		{"auth_time", timeColumn},
+		{"acr", textColumn},
+		{"amr", textColumn},
+		{"claims", jsonColumn},
+		{"subject", keyColumn},
+		{"audience", textColumn},
+		{"authorization_details", jsonColumn},
+		{"expires_at", timeColumn},
+		{"revoked_at", timeColumn},
+		{"revoked_reason", textColumn},
+	}},
+	{"access_data", []column{
+		{"tenant_id", tenantColumn},
+		{"access_token", keyColumn},
+		{"refresh_token", keyColumn},
+		{"expires_in", intColumn},
+		{"scope", textColumn},
+		{"redirect_uri", textColumn},
+		{"created_at", timeColumn},
+		{"user_data", jsonColumn},
+		{"authorize_data_code", keyColumn},
+		{"prev_access_data_token", keyColumn},
+		{"client_id", keyColumn},
+		{"subject", keyColumn},
+		{"cnf", jsonColumn},
+		{"audience", textColumn},
+		{"authorization_details", jsonColumn},
+		{"subject_token", keyColumn},
+		{"actor_token", keyColumn},
+		{"issued_token_type", textColumn},
+		{"expires_at", timeColumn},
+		{"revoked_at", timeColumn},
+		{"revoked_reason", textColumn},
+	}},
+	{"consents", []column{
+		{"tenant_id", tenantColumn},
+		{"subject", keyColumn},
+		{"client_id", keyColumn},
+		{"scope", textColumn},
+		{"granted_at", timeColumn},
+		{"expires_at", timeColumn},
+	}},
+	{"device_codes", []column{
+		{"tenant_id", tenantColumn},
+		{"device_code", keyColumn},
+		{"user_code", keyColumn},
+		{"client_id", keyColumn},
+		{"scope", textColumn},
+		{"expires_in", intColumn},
+		{"poll_interval", intColumn},
+		{"created_at", timeColumn},
+		{"status", keyColumn},
+		{"subject", keyColumn},
+		{"user_data", jsonColumn},
+		{"last_polled_at", timeColumn},
+	}},
+	{"par_requests", []column{
+		{"tenant_id", tenantColumn},
+		{"request_uri", keyColumn},
+		{"client_id", keyColumn},
+		{"parameters", textColumn},
+		{"expires_in", intColumn},
+		{"created_at", timeColumn},
+	}},
+	{"dpop_jti", []column{
+		{"tenant_id", tenantColumn},
+		{"jti", keyColumn},
+		{"expires_at", timeColumn},
+	}},
+	{"audit_events", []column{
+		{"tenant_id", tenantColumn},
+		{"id", keyColumn},
+		{"event_type", keyColumn},
+		{"client_id", keyColumn},
+		{"subject", keyColumn},
+		{"token_fingerprint", keyColumn},
+		{"created_at", timeColumn},
+	}},
+	{"outbox_events", []column{
+		{"tenant_id", tenantColumn},
+		{"id", keyColumn},
+		{"event_type", keyColumn},
+		{"client_id", keyColumn},
+		{"subject", keyColumn},
+		{"token_fingerprint", keyColumn},
+		{"created_at", timeColumn},
+	}},
+}
+
+// SchemaProblem is a table or column of the live database that does not match
+// the schema expected by the storage
+type SchemaProblem struct {
+	Table string
+	// Column is empty if the whole table is missing
+	Column string
+	// Type is the database type of a mistyped column, and empty if the column
+	// is missing
+	Type string
+	// Expected describes the expected type of the column
+	Expected string
+}
+
+func (problem SchemaProblem) String() string {
+	switch {
+	case problem.Column == "":
+		return "table " + problem.Table + " is missing"
+	case problem.Type == "":
+		return "column " + problem.Table + "." + problem.Column + " is missing"
+	}
+	return fmt.Sprintf("column %s.%s has type %s, expected %s", problem.Table, problem.Column,
+		problem.Type, problem.Expected)
+}
+
+// SchemaError is returned by VerifySchema if the database does not match the
+// schema expected by the storage
+type SchemaError struct {
+	Problems []SchemaProblem
+}
+
+func (err *SchemaError) Error() string {
+	problems := []string{}
+	for _, problem := range err.Problems {
+		problems = append(problems, problem.String())
+	}
+	return "sqlstore: schema mismatch (run Migrate or add the columns manually): " + strings.Join(problems, "; ")
+}
+
+// VerifySchema checks that the tables of the database have all of the columns the
+// storage uses, with compatible types. It returns a *SchemaError listing every
+// missing table, missing column and mistyped column. Additional columns are
+// allowed. Table and column names are reported as configured with WithNaming.
+// Query errors other than a missing table are returned unchanged.
+func (store *SQLStorage) VerifySchema(ctx context.Context) (err error) {
+	ctx, op := store.begin(ctx, "VerifySchema")
+	defer op.end(&err)
+	problems := []SchemaProblem{}
+	for _, table := range schemaTables {
+		tableName := store.naming.TableName(table.name)
+
+		rows, err := store.query(ctx, store.tableDB(table.name), store.rebind("SELECT * FROM "+table.name+" WHERE 1 = 0"))
+		if err != nil && store.dialect.isUndefinedTable(err) {
+			problems = append(problems, SchemaProblem{Table: tableName})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		columnTypes, err := rows.ColumnTypes()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		types := map[string]string{}
+		for _, columnType := range columnTypes {
+			types[strings.ToLower(columnType.Name())] = columnType.DatabaseTypeName()
+		}
+
+		for _, c := range table.columns {
+			name := store.naming.ColumnName(table.name, c.name)
+			typ, ok := types[strings.ToLower(name)]
+			if !ok {
+				problems = append(problems, SchemaProblem{Table: tableName, Column: name, Expected: typeClass(c.typ)})
+				continue
+			}
+			if !compatibleType(c.typ, typ) {
+				problems = append(problems, SchemaProblem{Table: tableName, Column: name, Type: typ,
+					Expected: typeClass(c.typ)})
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &SchemaError{Problems: problems}
+	}
+	return nil
+}
+
+// typeClass describes the database types that are compatible with a column type
+func typeClass(t columnType) string {
+	switch t {
+	case intColumn:
+		return "an integer type"
+	case timeColumn:
+		return "a date and time type"
+	case jsonColumn:
+		return "a string or json type"
+	}
+	return "a string type"
+}
+
+// compatibleType reports whether the database type name of a column can hold a
+// column type
+func compatibleType(t columnType, typeName string) bool {
+	typeName = strings.ToUpper(strings.TrimSpace(typeName))
+	if i := strings.IndexByte(typeName, '('); i >= 0 {
+		typeName = strings.TrimSpace(typeName[:i])
+	}
+
+	isString := strings.HasSuffix(typeName, "TEXT") || strings.HasSuffix(typeName, "CHAR") ||
+		strings.Contains(typeName, "CHARACTER") || typeName == "CLOB" || typeName == "BPCHAR" ||
+		typeName == "VARCHAR" || typeName == "NVARCHAR"
+
+	switch t {
+	case intColumn:
+		return strings.Contains(typeName, "INT") || typeName == "SERIAL" || typeName == "BIGSERIAL"
+	case timeColumn:
+		return strings.HasPrefix(typeName, "TIMESTAMP") || typeName == "DATETIME" || typeName == "DATE"
+	case jsonColumn:
+		return isString || typeName == "JSON" || typeName == "JSONB"
+	}
+	return isString
+}
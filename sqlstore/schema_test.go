@@ -0,0 +1,111 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"github.com/RangelReale/osin"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySchema(t *testing.T) {
+	ctx := context.Background()
+
+	// The gorm models match the schema
+	if err := testingContext.Store.VerifySchema(ctx); err != nil {
+		t.Error(err)
+	}
+
+	db := openMigratedDB(t)
+	defer db.Close()
+	store := NewSQLStorage(db)
+	if err := store.VerifySchema(ctx); err != nil {
+		t.Error(err)
+	}
+
+	// Additional columns are allowed and do not affect reads
+	for _, table := range []string{"clients", "authorize_data", "access_data"} {
+		if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN audit_note TEXT"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.VerifySchema(ctx); err != nil {
+		t.Error(err)
+	}
+
+	client := &osin.DefaultClient{Id: "schemaclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "schemaaccess", ExpiresIn: 100, CreatedAt: time.Now(),
+		Client: client, UserData: userData[0]}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	retAccessData, err := store.LoadAccess(accessData.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(retAccessData.UserData, userData[0]) {
+		t.Errorf("\"%v\": expected %v", retAccessData.UserData, userData[0])
+	}
+}
+
+func TestVerifySchemaProblems(t *testing.T) {
+	ctx := context.Background()
+	db := openMemoryDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db)
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"DROP TABLE dpop_jti",
+		"ALTER TABLE clients RENAME TO old_clients",
+		"CREATE TABLE clients (tenant_id VARCHAR(255), id VARCHAR(255), secret TEXT, redirect_uri TEXT)",
+		"ALTER TABLE consents RENAME TO old_consents",
+		"CREATE TABLE consents (tenant_id VARCHAR(255), subject VARCHAR(255), client_id VARCHAR(255), " +
+			"scope TEXT, granted_at TEXT, expires_at DATETIME)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := store.VerifySchema(ctx)
+	schemaErr, ok := err.(*SchemaError)
+	if !ok {
+		t.Fatalf("\"%v\": expected a *SchemaError", err)
+	}
+	expected := []SchemaProblem{
+		{Table: "clients", Column: "user_data", Expected: "a string or json type"},
+		{Table: "consents", Column: "granted_at", Type: "TEXT", Expected: "a date and time type"},
+		{Table: "dpop_jti"},
+	}
+	if !reflect.DeepEqual(schemaErr.Problems, expected) {
+		t.Errorf("\"%v\": expected %v", schemaErr.Problems, expected)
+	}
+	if !strings.Contains(err.Error(), "column consents.granted_at has type TEXT, expected a date and time type") {
+		t.Errorf("Unexpected error message %q", err.Error())
+	}
+}
+
+func TestVerifySchemaErrors(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+	store := NewSQLStorage(db)
+
+	// Errors other than a missing table are returned unchanged
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.VerifySchema(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("\"%v\": expected %v", err, context.Canceled)
+	}
+	db.Close()
+	if err := store.VerifySchema(context.Background()); err == nil || errors.As(err, new(*SchemaError)) {
+		t.Errorf("\"%v\": expected the query error", err)
+	}
+}
@@ -1,11 +1,16 @@
 package sqlstore
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"github.com/RangelReale/osin"
 	_ "github.com/jinzhu/gorm"
 	_ "github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"strings"
 	"time"
 )
 
@@ -26,6 +31,19 @@ import (
  * created_at   time.Time
  * user_data    string
  * client_id    string (foreign key)
+ * code_challenge        string
+ * code_challenge_method string
+ * nonce                 string    (nullable)
+ * auth_time             time.Time (nullable)
+ * acr                   string    (nullable)
+ * amr                   string    (nullable, space separated)
+ * claims                string    (nullable, json)
+ * subject               string    (nullable)
+ * audience              string    (nullable, space separated)
+ * authorization_details string    (nullable, json)
+ * expires_at            time.Time (nullable, index)
+ * revoked_at            time.Time (nullable, index)
+ * revoked_reason        string    (nullable)
  *
  * access_data:
  * access_token           string (primary key)
@@ -38,18 +56,168 @@ import (
  * authorize_data_code    string (foreign key)
  * prev_access_data_token string (foreign key)
  * client_id              string (foreign key)
+ * subject                string (nullable)
+ * cnf                    string (nullable, json)
+ * audience               string (nullable, space separated)
+ * authorization_details  string (nullable, json)
+ * subject_token          string (nullable, index)
+ * actor_token            string (nullable, index)
+ * issued_token_type      string (nullable)
+ * expires_at             time.Time (nullable, index)
+ * revoked_at             time.Time (nullable, index)
+ * revoked_reason         string (nullable)
+ *
+ * consents:
+ * subject      string (primary key)
+ * client_id    string (primary key)
+ * scope        string
+ * granted_at   time.Time
+ * expires_at   time.Time (nullable)
+ *
+ * device_codes:
+ * device_code    string (primary key)
+ * user_code      string (unique per tenant, normalized)
+ * client_id      string
+ * scope          string
+ * expires_in     int32
+ * poll_interval  int32
+ * created_at     time.Time
+ * status         string
+ * subject        string    (nullable)
+ * user_data      string    (nullable)
+ * last_polled_at time.Time (nullable)
+ *
+ * par_requests:
+ * request_uri  string (primary key)
+ * client_id    string
+ * parameters   string (json)
+ * expires_in   int32
+ * created_at   time.Time
+ *
+ * dpop_jti:
+ * jti          string (primary key)
+ * expires_at   time.Time (index)
+ *
+ * audit_events:
+ * id                string (primary key)
+ * event_type        string
+ * client_id         string    (index)
+ * subject           string    (nullable, index)
+ * token_fingerprint string    (nullable)
+ * created_at        time.Time (index)
+ *
+ * outbox_events has the columns of audit_events, with an index on created_at
+ *
+ * Every table also has a tenant_id column, which is the first column of its
+ * primary key (see WithTenant). Tables may have additional columns, and
+ * VerifySchema reports missing or mistyped columns.
+ *
+ * These are the default table and column names, which can be changed with
+ * WithNaming.
+ *
+ * The tables can be created with Migrate. With WithJSONUserData the user_data
+ * columns use the native json type of the dialect. With WithClientDB the clients
+ * table is stored in its own database and all other tables in the token database.
  */
 
 type SQLStorage struct {
-	authDB *sql.DB
+	// authDB is the token database. clientDB holds the client registry and is
+	// authDB unless WithClientDB is used.
+	authDB   *sql.DB
+	clientDB *sql.DB
+
+	// tenantID scopes every query of the storage
+	tenantID string
+
+	dialect    Dialect
+	nativeJSON bool
+	naming     Naming
+
+	detailTypes map[string]authorizationDetailType
+
+	// replicas is set by NewReplicatedSQLStorage
+	replicas             *replicaSet
+	readYourWritesWindow time.Duration
+
+	// clientLoader replaces GetClient when loading the client of authorize and
+	// access data (set by NewCachedStorage)
+	clientLoader func(ctx context.Context, id string) (osin.Client, error)
+
+	// metrics is set by WithMetrics and tracer by WithTracerProvider
+	metrics Metrics
+	tracer  trace.Tracer
+
+	// logger is set by WithLogger
+	logger             *slog.Logger
+	logLevels          LogLevels
+	slowQueryThreshold time.Duration
+
+	// auditEvents is set by WithAuditEvents and outbox by WithOutbox
+	auditEvents bool
+	outbox      bool
+
+	// softDelete is set by WithSoftDelete and revokedErrors by WithRevokedErrors
+	softDelete    bool
+	revokedErrors bool
+
+	// clock is set by WithClock and enforceExpiry by WithExpiryEnforcement
+	clock         func() time.Time
+	enforceExpiry bool
+
+	hooks *Hooks
 }
 
-func NewSQLStorage(authDB *sql.DB) *SQLStorage {
-	return &SQLStorage{
-		authDB: authDB,
+// Option configures optional behavior of a SQLStorage
+type Option func(*SQLStorage)
+
+// WithDialect sets the SQL dialect of the database (SQLite by default)
+func WithDialect(dialect Dialect) Option {
+	return func(store *SQLStorage) {
+		store.dialect = dialect
 	}
 }
 
+// WithClientDB stores the clients table in clientDB instead of the token database.
+// Migrate creates the clients table in clientDB.
+func WithClientDB(clientDB *sql.DB) Option {
+	return func(store *SQLStorage) {
+		store.clientDB = clientDB
+	}
+}
+
+// WithTenant binds the storage to a tenant. All rows are saved with the tenant id
+// and only the rows of the tenant are loaded, updated or removed, so several
+// authorization servers can share one database. The empty tenant is used by default.
+func WithTenant(tenantID string) Option {
+	return func(store *SQLStorage) {
+		store.tenantID = tenantID
+	}
+}
+
+// WithJSONUserData stores user data in the native json column type of the dialect
+// (jsonb on Postgres, JSON on MySQL) instead of an opaque string. Missing user data
+// is stored as NULL instead of an empty string.
+func WithJSONUserData() Option {
+	return func(store *SQLStorage) {
+		store.nativeJSON = true
+	}
+}
+
+func NewSQLStorage(authDB *sql.DB, opts ...Option) *SQLStorage {
+	store := &SQLStorage{
+		authDB:               authDB,
+		clientDB:             authDB,
+		readYourWritesWindow: DefaultReadYourWritesWindow,
+		logLevels:            DefaultLogLevels,
+		hooks:                &Hooks{},
+		clock:                time.Now,
+	}
+	for _, opt := range opts {
+		opt(store)
+	}
+	return store
+}
+
 func (store *SQLStorage) Clone() osin.Storage {
 	return store
 }
@@ -84,37 +252,95 @@ func setUserData(userData interface{}) (string, error) {
 	return userDataStr, err
 }
 
+// rebind applies the naming of the storage to a query written with the default names
+// and rewrites its placeholders for the dialect
+func (store *SQLStorage) rebind(query string) string {
+	return store.dialect.rebind(store.naming.rewrite(query))
+}
+
+// userDataArg returns the query argument for a marshaled user data string
+func (store *SQLStorage) userDataArg(userDataStr string) interface{} {
+	// Empty strings are not valid json
+	if store.nativeJSON && userDataStr == "" {
+		return nil
+	}
+	return userDataStr
+}
+
+// nullString returns the query argument for an optional string column
+func nullString(str string) interface{} {
+	if str == "" {
+		return nil
+	}
+	return str
+}
+
 func (store *SQLStorage) GetClient(id string) (osin.Client, error) {
+	return store.getClient(context.Background(), id)
+}
+
+func (store *SQLStorage) getClient(ctx context.Context, id string) (_ osin.Client, err error) {
+	ctx, op := store.begin(ctx, "GetClient")
+	defer op.end(&err)
 	var (
 		clientID    string
 		secret      string
 		redirectURI string
-		userDataStr string
+		userDataStr sql.NullString
 	)
 
-	row := store.authDB.QueryRow("SELECT * FROM clients WHERE id = ?", id)
+	db := store.clientReadDB(id)
+	if err := store.hooks.BeforeLoadClient.run(ctx, db, id); err != nil {
+		return nil, err
+	}
+	row := store.queryRow(ctx, db, store.rebind(`
+		SELECT id, secret, redirect_uri, user_data FROM clients WHERE tenant_id = ? AND id = ?
+		`), store.tenantID, id)
 
-	err := row.Scan(&clientID, &secret, &redirectURI, &userDataStr)
+	err = row.Scan(&clientID, &secret, &redirectURI, &userDataStr)
 	if err != nil {
 		return nil, err
 	}
 
 	// Unmarshal user data from string
-	userData, err := getUserData(userDataStr)
+	userData, err := getUserData(userDataStr.String)
 	if err != nil {
 		return nil, err
 	}
 
-	return &osin.DefaultClient{
+	client := &osin.DefaultClient{
 		Id:          clientID,
 		Secret:      secret,
 		RedirectUri: redirectURI,
 		UserData:    userData,
-	}, nil
+	}
+	if err := store.hooks.AfterLoadClient.run(ctx, db, client); err != nil {
+		return nil, err
+	}
+	return client, nil
 }
 
-func (store *SQLStorage) SetClient(client osin.Client) error {
-	stmt, err := store.authDB.Prepare("INSERT INTO clients(id, secret, redirect_uri, user_data) VALUES(?, ?, ?, ?)")
+// loadClient loads the client referenced by authorize or access data
+func (store *SQLStorage) loadClient(ctx context.Context, id string) (osin.Client, error) {
+	if store.clientLoader != nil {
+		return store.clientLoader(ctx, id)
+	}
+	return store.getClient(ctx, id)
+}
+
+func (store *SQLStorage) SetClient(client osin.Client) (err error) {
+	ctx, op := store.begin(context.Background(), "SetClient")
+	defer op.end(&err)
+
+	tx, err := store.clientDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := store.hooks.BeforeSaveClient.run(ctx, tx, client); err != nil {
+		return err
+	}
 
 	// Marshal user data into string
 	userDataStr, err := setUserData(client.GetUserData())
@@ -122,42 +348,140 @@ func (store *SQLStorage) SetClient(client osin.Client) error {
 		return err
 	}
 
-	_, err = stmt.Exec(client.GetId(), client.GetSecret(), client.GetRedirectUri(), userDataStr)
-	return err
+	store.recordWrite(clientWriteKey + client.GetId())
+
+	_, err = store.exec(ctx, tx, store.rebind(
+		"INSERT INTO clients(tenant_id, id, secret, redirect_uri, user_data) VALUES(?, ?, ?, ?, ?)"),
+		store.tenantID, client.GetId(), client.GetSecret(), client.GetRedirectUri(), store.userDataArg(userDataStr))
+	if err != nil {
+		return err
+	}
+	if err := store.hooks.AfterSaveClient.run(ctx, tx, client); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveClient removes a client together with the authorization codes, tokens,
+// consents, device codes and pushed requests issued to it. The client registry
+// may be a different database than the token database, so the token data is
+// removed in its own transaction first. If removing the client fails, no token
+// data is left for it and RemoveClient can be retried.
+func (store *SQLStorage) RemoveClient(id string) (err error) {
+	ctx, op := store.begin(context.Background(), "RemoveClient")
+	defer op.end(&err)
+	if err := store.removeClientData(ctx, id); err != nil {
+		return err
+	}
+
+	tx, err := store.clientDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	store.recordWrite(clientWriteKey + id)
+
+	_, err = store.exec(ctx, tx, store.rebind("DELETE FROM clients WHERE tenant_id = ? AND id = ?"),
+		store.tenantID, id)
+	if err != nil {
+		return err
+	}
+	if err := store.hooks.AfterRemoveClient.run(ctx, tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (store *SQLStorage) RemoveClient(id string) error {
-	stmt, err := store.authDB.Prepare("DELETE FROM clients WHERE id = ?")
+// clientDataTables are the tables of the token database with rows issued to a client
+var clientDataTables = []string{"access_data", "authorize_data", "consents", "device_codes", "par_requests"}
+
+// removeClientData removes the rows issued to a client from the token database
+func (store *SQLStorage) removeClientData(ctx context.Context, id string) error {
+	tx, err := store.authDB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = stmt.Exec(id)
-	return err
+	if err := store.hooks.BeforeRemoveClient.run(ctx, tx, id); err != nil {
+		return err
+	}
+	for _, table := range clientDataTables {
+		_, err := store.exec(ctx, tx, store.rebind("DELETE FROM "+table+" WHERE tenant_id = ? AND client_id = ?"),
+			store.tenantID, id)
+		if err != nil {
+			return err
+		}
+	}
+	if err := store.recordEvent(ctx, tx, AuditClientRemoved, id, "", ""); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (store *SQLStorage) SaveAuthorize(authorizeData *osin.AuthorizeData) error {
-	stmt, err := store.authDB.Prepare(`
-		INSERT INTO authorize_data(code, expires_in, scope, redirect_uri, state, created_at, user_data, client_id) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-		`)
+func (store *SQLStorage) SaveAuthorize(authorizeData *osin.AuthorizeData) (err error) {
+	ctx, op := store.begin(context.Background(), "SaveAuthorize")
+	defer op.end(&err)
+
+	tx, err := store.authDB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	if err := store.hooks.BeforeSaveAuthorize.run(ctx, tx, authorizeData); err != nil {
+		return err
+	}
 
 	// Marshal user data into string
-	userDataStr, err := setUserData(authorizeData.UserData)
+	userData, ext := splitUserData(authorizeData.UserData)
+	userDataStr, err := setUserData(userData)
 	if err != nil {
 		return err
 	}
 
-	_, err = stmt.Exec(authorizeData.Code, authorizeData.ExpiresIn, authorizeData.Scope,
+	details, err := store.authorizationDetailsArg(ext.AuthorizationDetails)
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{store.tenantID, authorizeData.Code, authorizeData.ExpiresIn, authorizeData.Scope,
 		authorizeData.RedirectUri, authorizeData.State, authorizeData.CreatedAt,
-		userDataStr, authorizeData.Client.GetId())
-	return err
+		store.userDataArg(userDataStr), authorizeData.Client.GetId(),
+		authorizeData.CodeChallenge, authorizeData.CodeChallengeMethod}
+	args = append(args, oidcArgs(ext.OIDC)...)
+	args = append(args, nullString(ext.Subject), audienceArg(ext.Audience), details, authorizeData.ExpireAt().UTC())
+
+	store.recordWrite(codeWriteKey + authorizeData.Code)
+	_, err = store.exec(ctx, tx, store.rebind(`
+		INSERT INTO authorize_data(tenant_id, code, expires_in, scope, redirect_uri, state, created_at, user_data, client_id,
+		code_challenge, code_challenge_method, nonce, auth_time, acr, amr, claims, subject, audience,
+		authorization_details, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`), args...)
+	if err != nil {
+		return err
+	}
+	err = store.recordEvent(ctx, tx, AuditAuthorizeCodeIssued, authorizeData.Client.GetId(), ext.Subject, authorizeData.Code)
+	if err != nil {
+		return err
+	}
+	if err := store.hooks.AfterSaveAuthorize.run(ctx, tx, authorizeData); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (store *SQLStorage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	return store.loadAuthorize(context.Background(), code, true)
+}
+
+// loadAuthorize loads the authorize data of a code. Expired codes are not found
+// if live is set and expiry is enforced.
+func (store *SQLStorage) loadAuthorize(ctx context.Context, code string, live bool) (_ *osin.AuthorizeData, err error) {
+	ctx, op := store.begin(ctx, "LoadAuthorize")
+	defer op.end(&err)
 	var (
 		authCode    string
 		expiresIn   int32
@@ -165,25 +489,56 @@ func (store *SQLStorage) LoadAuthorize(code string) (*osin.AuthorizeData, error)
 		redirectURI string
 		state       string
 		createdAt   time.Time
-		userDataStr string
+		userDataStr sql.NullString
 		clientID    string
-	)
 
-	row := store.authDB.QueryRow("SELECT * FROM authorize_data WHERE code = ?", code)
+		codeChallenge       sql.NullString
+		codeChallengeMethod sql.NullString
+		oidc                oidcColumns
+		subject             sql.NullString
+		audience            sql.NullString
+		details             sql.NullString
+		revokedAt           sql.NullTime
+		revokedReason       sql.NullString
+	)
 
-	err := row.Scan(&authCode, &expiresIn, &scope, &redirectURI, &state, &createdAt, &userDataStr, &clientID)
+	expiry, expiryArgs := store.unexpired(live)
+	db := store.readDB(codeWriteKey + code)
+	if err := store.hooks.BeforeLoadAuthorize.run(ctx, db, code); err != nil {
+		return nil, err
+	}
+	row := store.queryRow(ctx, db, store.rebind(`
+		SELECT code, expires_in, scope, redirect_uri, state, created_at, user_data, client_id,
+		code_challenge, code_challenge_method, nonce, auth_time, acr, amr, claims, subject, audience,
+		authorization_details, revoked_at, revoked_reason
+		FROM authorize_data WHERE tenant_id = ? AND code = ?`+expiry), append([]interface{}{store.tenantID, code}, expiryArgs...)...)
+
+	dest := []interface{}{&authCode, &expiresIn, &scope, &redirectURI, &state, &createdAt, &userDataStr, &clientID,
+		&codeChallenge, &codeChallengeMethod}
+	dest = append(dest, oidc.dest()...)
+	dest = append(dest, &subject, &audience, &details, &revokedAt, &revokedReason)
+
+	err = row.Scan(dest...)
 	if err != nil {
 		return nil, err
 	}
+	if revokedAt.Valid {
+		return nil, store.revokedError(revokedAt.Time, revokedReason.String)
+	}
 
 	// Unmarshal the user data from string
-	userData, err := getUserData(userDataStr)
+	userData, err := getUserData(userDataStr.String)
+	if err != nil {
+		return nil, err
+	}
+
+	authorizationDetails, err := store.getAuthorizationDetails(details.String, false)
 	if err != nil {
 		return nil, err
 	}
 
 	// Retrieve the client from the client id
-	client, err := store.GetClient(clientID)
+	client, err := store.loadClient(ctx, clientID)
 	if err != nil {
 		return nil, err
 	}
@@ -195,35 +550,88 @@ func (store *SQLStorage) LoadAuthorize(code string) (*osin.AuthorizeData, error)
 		RedirectUri: redirectURI,
 		State:       state,
 		CreatedAt:   createdAt,
-		UserData:    userData,
-		Client:      client,
+		UserData: joinUserData(userData, &ExtendedUserData{
+			OIDC:                 oidc.data(),
+			Subject:              subject.String,
+			Audience:             strings.Fields(audience.String),
+			AuthorizationDetails: authorizationDetails,
+		}),
+		Client: client,
+
+		CodeChallenge:       codeChallenge.String,
+		CodeChallengeMethod: codeChallengeMethod.String,
 	}
 
+	if err := store.hooks.AfterLoadAuthorize.run(ctx, db, authData); err != nil {
+		return nil, err
+	}
 	return authData, nil
 }
 
-func (store *SQLStorage) RemoveAuthorize(code string) error {
-	stmt, err := store.authDB.Prepare("DELETE FROM authorize_data WHERE code = ?")
+func (store *SQLStorage) RemoveAuthorize(code string) (err error) {
+	ctx, op := store.begin(context.Background(), "RemoveAuthorize")
+	defer op.end(&err)
+	return store.removeAuthorize(ctx, code, store.softDelete, RevokedReasonRemoved)
+}
+
+// removeAuthorize removes the authorize data of a code, or revokes it with reason if soft is set
+func (store *SQLStorage) removeAuthorize(ctx context.Context, code string, soft bool, reason string) error {
+	tx, err := store.authDB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	if err := store.hooks.BeforeRemoveAuthorize.run(ctx, tx, code); err != nil {
+		return err
+	}
+	if err := store.recordRemoval(ctx, tx, AuditAuthorizeCodeRemoved, "authorize_data", "code", code); err != nil {
+		return err
+	}
 
-	_, err = stmt.Exec(code)
-	return err
+	store.recordWrite(codeWriteKey + code)
+
+	_, err = store.removeRows(ctx, tx, "authorize_data", "code = ?", []interface{}{code}, soft, reason)
+	if err != nil {
+		return err
+	}
+	if err := store.hooks.AfterRemoveAuthorize.run(ctx, tx, code); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (store *SQLStorage) SaveAccess(accessData *osin.AccessData) error {
-	stmt, err := store.authDB.Prepare(`
-		INSERT INTO access_data(access_token, refresh_token,
-		expires_in, scope, redirect_uri, created_at, user_data, authorize_data_code, prev_access_data_token, client_id)
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`)
+func (store *SQLStorage) SaveAccess(accessData *osin.AccessData) (err error) {
+	ctx, op := store.begin(context.Background(), "SaveAccess")
+	defer op.end(&err)
+
+	tx, err := store.authDB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// Marshal user data into string
-	userDataStr, err := setUserData(accessData.UserData)
+	if err := store.hooks.BeforeSaveAccess.run(ctx, tx, accessData); err != nil {
+		return err
+	}
+
+	// Marshal user data into string. The OpenID Connect values of the extended
+	// user data only belong to the authorize data.
+	userData, ext := splitUserData(accessData.UserData)
+	userDataStr, err := setUserData(userData)
+	if err != nil {
+		return err
+	}
+
+	cnf, err := confirmationArg(ext.Confirmation)
+	if err != nil {
+		return err
+	}
+	audience, err := accessAudience(accessData, ext.Audience)
+	if err != nil {
+		return err
+	}
+	details, err := store.authorizationDetailsArg(ext.AuthorizationDetails)
 	if err != nil {
 		return err
 	}
@@ -238,15 +646,45 @@ func (store *SQLStorage) SaveAccess(accessData *osin.AccessData) error {
 		authDataCode = accessData.AuthorizeData.Code
 	}
 
-	_, err = stmt.Exec(accessData.AccessToken, accessData.RefreshToken, accessData.ExpiresIn,
-		accessData.Scope, accessData.RedirectUri, accessData.CreatedAt, userDataStr, authDataCode,
-		prevAccessDataToken, accessData.Client.GetId())
-	return err
+	args := []interface{}{store.tenantID, accessData.AccessToken, accessData.RefreshToken, accessData.ExpiresIn,
+		accessData.Scope, accessData.RedirectUri, accessData.CreatedAt, store.userDataArg(userDataStr), authDataCode,
+		prevAccessDataToken, accessData.Client.GetId(), nullString(ext.Subject), cnf, audienceArg(audience),
+		details}
+	args = append(args, tokenExchangeArgs(ext.TokenExchange)...)
+	args = append(args, accessData.ExpireAt().UTC())
+
+	store.recordWrite(tokenWriteKey + accessData.AccessToken)
+	if accessData.RefreshToken != "" {
+		store.recordWrite(tokenWriteKey + accessData.RefreshToken)
+	}
+	_, err = store.exec(ctx, tx, store.rebind(`
+		INSERT INTO access_data(tenant_id, access_token, refresh_token,
+		expires_in, scope, redirect_uri, created_at, user_data, authorize_data_code, prev_access_data_token, client_id,
+		subject, cnf, audience, authorization_details, subject_token, actor_token, issued_token_type, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`), args...)
+	if err != nil {
+		return err
+	}
+
+	eventType := AuditAccessTokenIssued
+	if prevAccessDataToken != "" {
+		eventType = AuditAccessTokenRefreshed
+	}
+	err = store.recordEvent(ctx, tx, eventType, accessData.Client.GetId(), ext.Subject, accessData.AccessToken)
+	if err != nil {
+		return err
+	}
+	if err := store.hooks.AfterSaveAccess.run(ctx, tx, accessData); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // loadAccess loads all of the access data except for the foreign key data
-// (to avoid loading the entire chain of access data)
-func (store *SQLStorage) loadAccess(token string, isRefresh ...bool) (*osin.AccessData, string, string, string, error) {
+// (to avoid loading the entire chain of access data) from db. Expired access
+// tokens are not found if live is set and expiry is enforced.
+func (store *SQLStorage) loadAccess(ctx context.Context, db Querier, token string, live bool, isRefresh ...bool) (*osin.AccessData, string, string, string, error) {
 	var (
 		accessToken         string
 		refreshToken        string
@@ -254,33 +692,64 @@ func (store *SQLStorage) loadAccess(token string, isRefresh ...bool) (*osin.Acce
 		scope               string
 		redirectURI         string
 		createdAt           time.Time
-		userDataStr         string
+		userDataStr         sql.NullString
 		authorizeDataCode   string
 		prevAccessDataToken string
 		clientID            string
+		subject             sql.NullString
+		cnf                 sql.NullString
+		audience            sql.NullString
+		details             sql.NullString
+		exchange            tokenExchangeColumns
+		revokedAt           sql.NullTime
+		revokedReason       sql.NullString
 	)
 
-	var rows *sql.Rows
-	var err error
+	key := "access_token"
 	if len(isRefresh) > 0 && isRefresh[0] == true {
-		rows, err = store.authDB.Query("SELECT * FROM access_data WHERE refresh_token = ?", token)
-	} else {
-		rows, err = store.authDB.Query("SELECT * FROM access_data WHERE access_token = ?", token)
+		key = "refresh_token"
+		live = false
+	}
+	expiry, expiryArgs := store.unexpired(live)
+	rows, err := store.query(ctx, db, store.rebind(`
+		SELECT access_token, refresh_token, expires_in, scope, redirect_uri, created_at, user_data,
+		authorize_data_code, prev_access_data_token, client_id, subject, cnf, audience, authorization_details,
+		subject_token, actor_token, issued_token_type, revoked_at, revoked_reason
+		FROM access_data WHERE tenant_id = ? AND `+key+` = ?`+expiry), append([]interface{}{store.tenantID, token}, expiryArgs...)...)
+	if err != nil {
+		return nil, "", "", "", err
 	}
 	defer rows.Close()
 
-	for rows.Next() {
-		err = rows.Scan(&accessToken, &refreshToken,
-			&expiresIn, &scope, &redirectURI, &createdAt, &userDataStr,
-			&authorizeDataCode, &prevAccessDataToken, &clientID)
-		if err != nil {
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
 			return nil, "", "", "", err
 		}
-		break
+		return nil, "", "", "", sql.ErrNoRows
+	}
+	dest := []interface{}{&accessToken, &refreshToken,
+		&expiresIn, &scope, &redirectURI, &createdAt, &userDataStr,
+		&authorizeDataCode, &prevAccessDataToken, &clientID, &subject, &cnf, &audience, &details}
+	dest = append(dest, exchange.dest()...)
+	dest = append(dest, &revokedAt, &revokedReason)
+	err = rows.Scan(dest...)
+	if err != nil {
+		return nil, "", "", "", err
+	}
+	if revokedAt.Valid {
+		return nil, "", "", "", store.revokedError(revokedAt.Time, revokedReason.String)
 	}
 
 	// Unmarshal user data from string
-	userData, err := getUserData(userDataStr)
+	userData, err := getUserData(userDataStr.String)
+	if err != nil {
+		return nil, "", "", "", err
+	}
+	confirmation, err := getConfirmation(cnf.String)
+	if err != nil {
+		return nil, "", "", "", err
+	}
+	authorizationDetails, err := store.getAuthorizationDetails(details.String, false)
 	if err != nil {
 		return nil, "", "", "", err
 	}
@@ -291,75 +760,183 @@ func (store *SQLStorage) loadAccess(token string, isRefresh ...bool) (*osin.Acce
 		ExpiresIn:    expiresIn,
 		Scope:        scope,
 		RedirectUri:  redirectURI,
-		UserData:     userData,
-		CreatedAt:    createdAt,
-	}, authorizeDataCode, prevAccessDataToken, clientID, err
+		UserData: joinUserData(userData, &ExtendedUserData{
+			Subject:              subject.String,
+			Audience:             strings.Fields(audience.String),
+			AuthorizationDetails: authorizationDetails,
+			Confirmation:         confirmation,
+			TokenExchange:        exchange.data(),
+		}),
+		CreatedAt: createdAt,
+	}, authorizeDataCode, prevAccessDataToken, clientID, nil
 }
 
-func (store *SQLStorage) LoadAccess(token string) (*osin.AccessData, error) {
-	accessData, authDataCode, prevAccessDataToken, clientID, err := store.loadAccess(token)
+// loadAccessReferences loads the client, authorize data and previous access data
+// referenced by access data. The authorize data and previous access data are
+// usually removed or revoked by osin once a token is issued or refreshed, so they
+// are left nil if they no longer exist.
+func (store *SQLStorage) loadAccessReferences(ctx context.Context, accessData *osin.AccessData, authDataCode, prevAccessDataToken, clientID string) error {
 	// load previous access data if the token is not empty
-	var prevAccessData *osin.AccessData
 	if prevAccessDataToken != "" {
-		prevAccessData, _, _, _, err = store.loadAccess(prevAccessDataToken)
-		if err != nil {
-			return nil, err
+		prevAccessData, _, _, _, err := store.loadAccess(ctx, store.readDB(tokenWriteKey+prevAccessDataToken),
+			prevAccessDataToken, false)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
 		}
+		accessData.AccessData = prevAccessData
 	}
 	// load client data
-	client, err := store.GetClient(clientID)
+	client, err := store.loadClient(ctx, clientID)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	accessData.Client = client
 	// load authorize data
-	authData, err := store.LoadAuthorize(authDataCode)
+	if authDataCode != "" {
+		authData, err := store.loadAuthorize(ctx, authDataCode, false)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		accessData.AuthorizeData = authData
+	}
+	return nil
+}
 
-	accessData.Client = client
-	accessData.AuthorizeData = authData
-	accessData.AccessData = prevAccessData
-	return accessData, err
+// LoadAccess loads the access data of an access token with its client, authorize data and
+// previous access data. The authorize data and previous access data are nil if
+// they were removed, which osin does once a token is issued or refreshed.
+func (store *SQLStorage) LoadAccess(token string) (_ *osin.AccessData, err error) {
+	ctx, op := store.begin(context.Background(), "LoadAccess")
+	defer op.end(&err)
+	db := store.readDB(tokenWriteKey + token)
+	if err := store.hooks.BeforeLoadAccess.run(ctx, db, token); err != nil {
+		return nil, err
+	}
+	accessData, authDataCode, prevAccessDataToken, clientID, err := store.loadAccess(ctx, db, token, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.loadAccessReferences(ctx, accessData, authDataCode, prevAccessDataToken, clientID); err != nil {
+		return nil, err
+	}
+	if err := store.hooks.AfterLoadAccess.run(ctx, db, accessData); err != nil {
+		return nil, err
+	}
+	return accessData, nil
+}
+
+func (store *SQLStorage) RemoveAccess(token string) (err error) {
+	ctx, op := store.begin(context.Background(), "RemoveAccess")
+	defer op.end(&err)
+	return store.removeAccess(ctx, token, store.softDelete, RevokedReasonRemoved)
 }
 
-func (store *SQLStorage) RemoveAccess(token string) error {
-	stmt, err := store.authDB.Prepare("DELETE FROM access_data WHERE access_token = ?")
+// removeAccess removes the access data of an access token, or revokes it with reason if soft is set
+func (store *SQLStorage) removeAccess(ctx context.Context, token string, soft bool, reason string) error {
+	tx, err := store.authDB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = stmt.Exec(token)
-	return err
+	if err := store.removeAccessTx(ctx, tx, token, soft, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (store *SQLStorage) LoadRefresh(token string) (*osin.AccessData, error) {
-	accessData, authDataCode, prevAccessDataToken, clientID, err := store.loadAccess(token, true)
-	// load previous access data if the token is not empty
-	var prevAccessData *osin.AccessData
-	if prevAccessDataToken != "" {
-		prevAccessData, _, _, _, err = store.loadAccess(prevAccessDataToken)
-		if err != nil {
-			return nil, err
-		}
+// removeAccessTx removes or revokes the access data of an access token in tx,
+// records the removal and runs the RemoveAccess hooks
+func (store *SQLStorage) removeAccessTx(ctx context.Context, tx Querier, token string, soft bool, reason string) error {
+	if err := store.hooks.BeforeRemoveAccess.run(ctx, tx, token); err != nil {
+		return err
 	}
-	// load client data
-	client, err := store.GetClient(clientID)
+	if err := store.recordRemoval(ctx, tx, AuditAccessTokenRemoved, "access_data", "access_token", token); err != nil {
+		return err
+	}
+
+	store.recordWrite(tokenWriteKey + token)
+
+	_, err := store.removeRows(ctx, tx, "access_data", "access_token = ?", []interface{}{token}, soft, reason)
+	if err != nil {
+		return err
+	}
+	return store.hooks.AfterRemoveAccess.run(ctx, tx, token)
+}
+
+// LoadRefresh loads the access data of a refresh token with its client, authorize data and
+// previous access data. The authorize data and previous access data are nil if
+// they were removed, which osin does once a token is issued or refreshed.
+func (store *SQLStorage) LoadRefresh(token string) (_ *osin.AccessData, err error) {
+	ctx, op := store.begin(context.Background(), "LoadRefresh")
+	defer op.end(&err)
+	db := store.readDB(tokenWriteKey + token)
+	if err := store.hooks.BeforeLoadRefresh.run(ctx, db, token); err != nil {
+		return nil, err
+	}
+	accessData, authDataCode, prevAccessDataToken, clientID, err := store.loadAccess(ctx, db, token, false, true)
 	if err != nil {
 		return nil, err
 	}
-	// load authorize data
-	authData, err := store.LoadAuthorize(authDataCode)
+	if err := store.loadAccessReferences(ctx, accessData, authDataCode, prevAccessDataToken, clientID); err != nil {
+		return nil, err
+	}
+	if err := store.hooks.AfterLoadAccess.run(ctx, db, accessData); err != nil {
+		return nil, err
+	}
+	return accessData, nil
+}
 
-	accessData.Client = client
-	accessData.AuthorizeData = authData
-	accessData.AccessData = prevAccessData
-	return accessData, err
+func (store *SQLStorage) RemoveRefresh(token string) (err error) {
+	ctx, op := store.begin(context.Background(), "RemoveRefresh")
+	defer op.end(&err)
+	return store.removeRefresh(ctx, token, store.softDelete, RevokedReasonRemoved)
 }
 
-func (store *SQLStorage) RemoveRefresh(token string) error {
-	stmt, err := store.authDB.Prepare("DELETE FROM access_data WHERE refresh_token = ?")
+// removeRefresh removes the access data of a refresh token, or revokes it with reason if soft is set
+func (store *SQLStorage) removeRefresh(ctx context.Context, token string, soft bool, reason string) error {
+	// The access token of the refresh token is removed as well
+	if store.replicas != nil {
+		accessToken, err := store.refreshAccessToken(ctx, token)
+		if err != nil {
+			return err
+		}
+		store.recordWrite(tokenWriteKey + accessToken)
+	}
+
+	tx, err := store.authDB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = stmt.Exec(token)
-	return err
+	if err := store.hooks.BeforeRemoveRefresh.run(ctx, tx, token); err != nil {
+		return err
+	}
+	if err := store.recordRemoval(ctx, tx, AuditRefreshTokenRemoved, "access_data", "refresh_token", token); err != nil {
+		return err
+	}
+
+	store.recordWrite(tokenWriteKey + token)
+
+	_, err = store.removeRows(ctx, tx, "access_data", "refresh_token = ?", []interface{}{token}, soft, reason)
+	if err != nil {
+		return err
+	}
+	if err := store.hooks.AfterRemoveRefresh.run(ctx, tx, token); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// refreshAccessToken returns the access token of a refresh token, or an empty
+// string if the refresh token does not exist
+func (store *SQLStorage) refreshAccessToken(ctx context.Context, refreshToken string) (string, error) {
+	var accessToken string
+	row := store.queryRow(ctx, store.authDB, store.rebind(
+		"SELECT access_token FROM access_data WHERE tenant_id = ? AND refresh_token = ?"), store.tenantID, refreshToken)
+	if err := row.Scan(&accessToken); err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return accessToken, nil
 }
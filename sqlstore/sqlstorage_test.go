@@ -27,7 +27,10 @@ func setupDB() {
 
 	// create tables
 	// db.LogMode(true)
-	db.AutoMigrate(&gorm_schema.Client{}, &gorm_schema.AuthorizeData{}, &gorm_schema.AccessData{})
+	db.AutoMigrate(&gorm_schema.Client{}, &gorm_schema.AuthorizeData{}, &gorm_schema.AccessData{},
+		&gorm_schema.Consent{}, &gorm_schema.DeviceCode{}, &gorm_schema.PushedRequest{},
+		&gorm_schema.DPoPJTI{}, &gorm_schema.AuditEvent{},
+		&gorm_schema.OutboxEvent{})
 	db.Model(&gorm_schema.AccessData{}).AddForeignKey("authorize_data_code", "authorize_data", "CASCADE", "RESTRICT")
 	db.Model(&gorm_schema.AccessData{}).AddForeignKey("prev_access_data_token", "access_data", "CASCADE", "RESTRICT")
 
@@ -78,7 +81,8 @@ var authDataTests = []osin.AuthorizeData{
 	osin.AuthorizeData{Code: "testcode", ExpiresIn: 100, Scope: "testscope", RedirectUri: "testredirect",
 		State: "teststate", CreatedAt: time.Date(2015, 2, 30, 6, 30, 0, 0, time.Local), UserData: userData[0]},
 	osin.AuthorizeData{Code: "testcode2", ExpiresIn: 100, Scope: "testscope", RedirectUri: "testredirect",
-		State: "teststate", CreatedAt: time.Date(2015, 2, 30, 6, 30, 0, 0, time.Local), UserData: nil},
+		State: "teststate", CreatedAt: time.Date(2015, 2, 30, 6, 30, 0, 0, time.Local), UserData: nil,
+		CodeChallenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", CodeChallengeMethod: "S256"},
 }
 
 // List of input tests for AccessData
@@ -371,6 +375,9 @@ func compareAuthData(authData1, authData2 *osin.AuthorizeData) bool {
 		Scope       string
 		RedirectUri string
 		State       string
+
+		CodeChallenge       string
+		CodeChallengeMethod string
 	}
 
 	testAuthData1 := testAuthDataType{
@@ -379,6 +386,9 @@ func compareAuthData(authData1, authData2 *osin.AuthorizeData) bool {
 		Scope:       authData1.Scope,
 		RedirectUri: authData1.RedirectUri,
 		State:       authData1.State,
+
+		CodeChallenge:       authData1.CodeChallenge,
+		CodeChallengeMethod: authData1.CodeChallengeMethod,
 	}
 
 	testAuthData2 := testAuthDataType{
@@ -387,6 +397,9 @@ func compareAuthData(authData1, authData2 *osin.AuthorizeData) bool {
 		Scope:       authData2.Scope,
 		RedirectUri: authData2.RedirectUri,
 		State:       authData2.State,
+
+		CodeChallenge:       authData2.CodeChallenge,
+		CodeChallengeMethod: authData2.CodeChallengeMethod,
 	}
 
 	// Compare the createdAt fields, and the Client fields, and the other fields in AuthorizeData
@@ -434,3 +447,66 @@ func compareAccessData(accessData1, accessData2 *osin.AccessData) bool {
 	}
 	return accessData1.CreatedAt.Equal(accessData2.CreatedAt)
 }
+
+// osin removes the authorize data once it issued a token and the previous access
+// data once it refreshed a token, so loads must not fail on the removed references
+func TestLoadAccessRemovedReferences(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	store := NewSQLStorage(db)
+	client := &osin.DefaultClient{Id: "referenceclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	authData := &osin.AuthorizeData{Code: "referencecode", ExpiresIn: 100, RedirectUri: "redirect",
+		CreatedAt: time.Now(), Client: client}
+	if err := store.SaveAuthorize(authData); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "referenceaccess", RefreshToken: "referencerefresh", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client, AuthorizeData: authData}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	refreshed := &osin.AccessData{AccessToken: "referenceaccess2", RefreshToken: "referencerefresh2", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client, AccessData: accessData}
+	if err := store.SaveAccess(refreshed); err != nil {
+		t.Fatal(err)
+	}
+
+	// The references are loaded while they exist
+	loaded, err := store.LoadAccess(accessData.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.AuthorizeData == nil || loaded.AuthorizeData.Code != authData.Code {
+		t.Errorf("\"%v\": expected %v", loaded.AuthorizeData, authData.Code)
+	}
+	loaded, err = store.LoadRefresh(refreshed.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.AccessData == nil || loaded.AccessData.AccessToken != accessData.AccessToken {
+		t.Errorf("\"%v\": expected %v", loaded.AccessData, accessData.AccessToken)
+	}
+
+	if err := store.RemoveAuthorize(authData.Code); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveRefresh(accessData.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	for _, load := range []func() (*osin.AccessData, error){
+		func() (*osin.AccessData, error) { return store.LoadAccess(refreshed.AccessToken) },
+		func() (*osin.AccessData, error) { return store.LoadRefresh(refreshed.RefreshToken) },
+	} {
+		loaded, err := load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.AccessData != nil || loaded.AuthorizeData != nil || loaded.Client.GetId() != client.Id {
+			t.Errorf("Unexpected access data %+v", loaded)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// Querier runs statements on a *sql.DB or *sql.Tx. Hooks receive the Querier of
+// the transaction of a write or of the database data was loaded from.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// operation is a call of a storage method
+type operation struct {
+	store  *SQLStorage
+	ctx    context.Context
+	method string
+	start  time.Time
+	span   trace.Span
+}
+
+// methodKey is the context key of the storage method that runs a statement
+type methodKey struct{}
+
+// begin starts a call of a storage method. The returned context carries the span
+// of the method and is passed to the statements of the call.
+func (store *SQLStorage) begin(ctx context.Context, method string) (context.Context, *operation) {
+	op := &operation{store: store, method: method, start: time.Now()}
+	if store.tracer != nil {
+		ctx, op.span = store.tracer.Start(ctx, "sqlstore."+method,
+			trace.WithAttributes(attrOperation.String(method)))
+	}
+	if store.logger != nil {
+		ctx = context.WithValue(ctx, methodKey{}, method)
+	}
+	op.ctx = ctx
+	return ctx, op
+}
+
+// end finishes a call of a storage method. It is deferred with a pointer to the
+// error result of the method.
+func (op *operation) end(err *error) {
+	duration := time.Since(op.start)
+	if op.span != nil {
+		endSpan(op.span, *err)
+	}
+	if op.store.metrics != nil {
+		op.store.metrics.ObserveLatency(op.method, duration)
+		if *err != nil {
+			op.store.metrics.IncError(op.method, ErrorKind(*err))
+		}
+	}
+	if op.store.logger != nil {
+		op.store.logCall(op.ctx, op.method, duration, *err)
+	}
+}
+
+// statement is a SQL statement run by a storage method
+type statement struct {
+	store *SQLStorage
+	ctx   context.Context
+	query string
+	args  []interface{}
+	start time.Time
+	span  trace.Span
+}
+
+// startStatement starts running a statement
+func (store *SQLStorage) startStatement(ctx context.Context, query string, args []interface{}) (context.Context, *statement) {
+	stmt := &statement{store: store, ctx: ctx, query: query, args: args, start: time.Now()}
+	if store.tracer != nil {
+		operation, table := statementTarget(query)
+		name := operation
+		if table != "" {
+			name += " " + table
+		}
+		ctx, stmt.span = store.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attrDBOperation.String(operation), attrDBTable.String(table)))
+	}
+	return ctx, stmt
+}
+
+// end finishes a statement. rowsAffected is negative if it is not known.
+func (stmt *statement) end(err error, rowsAffected int64) {
+	if stmt.span != nil {
+		if rowsAffected >= 0 {
+			stmt.span.SetAttributes(attrRowsAffected.Int64(rowsAffected))
+		}
+		endSpan(stmt.span, err)
+	}
+	if stmt.store.logger != nil {
+		stmt.store.logStatement(stmt.ctx, stmt.query, stmt.args, time.Since(stmt.start), rowsAffected, err)
+	}
+}
+
+// exec runs a statement that returns no rows
+func (store *SQLStorage) exec(ctx context.Context, db Querier, query string, args ...interface{}) (sql.Result, error) {
+	ctx, stmt := store.startStatement(ctx, query, args)
+	result, err := db.ExecContext(ctx, query, args...)
+	rowsAffected := int64(-1)
+	if err == nil {
+		if rows, rowsErr := result.RowsAffected(); rowsErr == nil {
+			rowsAffected = rows
+		}
+	}
+	stmt.end(err, rowsAffected)
+	return result, err
+}
+
+// query runs a statement that returns rows
+func (store *SQLStorage) query(ctx context.Context, db Querier, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, stmt := store.startStatement(ctx, query, args)
+	rows, err := db.QueryContext(ctx, query, args...)
+	stmt.end(err, -1)
+	return rows, err
+}
+
+// queryRow runs a statement that returns at most one row
+func (store *SQLStorage) queryRow(ctx context.Context, db Querier, query string, args ...interface{}) *sql.Row {
+	ctx, stmt := store.startStatement(ctx, query, args)
+	row := db.QueryRowContext(ctx, query, args...)
+	stmt.end(row.Err(), -1)
+	return row
+}
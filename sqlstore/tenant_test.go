@@ -0,0 +1,135 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/RangelReale/osin"
+	"testing"
+	"time"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	ctx := context.Background()
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	tenantA := NewSQLStorage(db, WithTenant("tenant-a"))
+	tenantB := NewSQLStorage(db, WithTenant("tenant-b"))
+
+	// Both tenants can register a client with the same id
+	clientA := &osin.DefaultClient{Id: "app", Secret: "secret-a", RedirectUri: "redirect-a"}
+	clientB := &osin.DefaultClient{Id: "app", Secret: "secret-b", RedirectUri: "redirect-b"}
+	if err := tenantA.SetClient(clientA); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenantB.SetClient(clientB); err != nil {
+		t.Fatal(err)
+	}
+	if client, err := tenantB.GetClient("app"); err != nil || client.GetSecret() != clientB.Secret {
+		t.Errorf("\"%v\", %v: expected %v", client, err, clientB)
+	}
+
+	onlyA := &osin.DefaultClient{Id: "onlya", Secret: "secret", RedirectUri: "redirect",
+		UserData: map[string]interface{}{"tenant": "a"}}
+	if err := tenantA.SetClient(onlyA); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenantB.GetClient(onlyA.Id); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+
+	authData := &osin.AuthorizeData{Code: "tenantcode", ExpiresIn: 100, RedirectUri: "redirect",
+		CreatedAt: time.Now(), Client: clientA}
+	if err := tenantA.SaveAuthorize(authData); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "tenantaccess", RefreshToken: "tenantrefresh", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: clientA}
+	if err := tenantA.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tenantB.LoadAuthorize(authData.Code); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+	if _, err := tenantB.LoadAccess(accessData.AccessToken); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+	if _, err := tenantB.LoadRefresh(accessData.RefreshToken); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+	if keys, err := tenantA.QueryByUserData(ctx, "clients", "$.tenant", "a"); err != nil || len(keys) != 1 {
+		t.Errorf("\"%v\", %v: expected [onlya]", keys, err)
+	}
+	if keys, err := tenantB.QueryByUserData(ctx, "clients", "$.tenant", "a"); err != nil || len(keys) != 0 {
+		t.Errorf("\"%v\", %v: expected no clients", keys, err)
+	}
+
+	// Removals of another tenant do not affect the tenant's rows
+	if err := tenantB.RemoveAuthorize(authData.Code); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenantB.RemoveAccess(accessData.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenantB.RemoveRefresh(accessData.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenantB.RemoveClient("app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenantA.LoadAuthorize(authData.Code); err != nil {
+		t.Error(err)
+	}
+	retAccessData, err := tenantA.LoadAccess(accessData.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retAccessData.Client.GetSecret() != clientA.Secret {
+		t.Errorf("\"%v\": expected %v", retAccessData.Client.GetSecret(), clientA.Secret)
+	}
+}
+
+func TestTenantMigration(t *testing.T) {
+	ctx := context.Background()
+	db := openMemoryDB(t)
+	defer db.Close()
+
+	// Create the schema as it was before tenants were added
+	store := NewSQLStorage(db)
+	for _, m := range migrations {
+		if m.version >= 11 {
+			break
+		}
+		if err := m.up(ctx, store); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := &osin.DefaultClient{Id: "legacy", Secret: "secret", RedirectUri: "redirect"}
+	if _, err := db.Exec("INSERT INTO clients(id, secret, redirect_uri) VALUES(?, ?, ?)",
+		client.Id, client.Secret, client.RedirectUri); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Existing rows belong to the default tenant
+	if _, err := store.GetClient(client.Id); err != nil {
+		t.Error(err)
+	}
+	if err := NewSQLStorage(db, WithTenant("other")).SetClient(client); err != nil {
+		t.Errorf("Client ids should be unique per tenant: %v", err)
+	}
+
+	// The indexes survive rebuilding the tables
+	var count int
+	row := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_access_data_refresh_token'")
+	if err := row.Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("\"%v\": expected 1", count)
+	}
+}
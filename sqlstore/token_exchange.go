@@ -0,0 +1,170 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Token type identifiers of RFC 8693
+const (
+	TokenTypeAccessToken  = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeRefreshToken = "urn:ietf:params:oauth:token-type:refresh_token"
+	TokenTypeIDToken      = "urn:ietf:params:oauth:token-type:id_token"
+	TokenTypeJWT          = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// TokenExchange records the tokens an access token was issued for by a token
+// exchange. SubjectToken and ActorToken usually are access tokens of other
+// access_data rows.
+type TokenExchange struct {
+	SubjectToken    string
+	ActorToken      string
+	IssuedTokenType string
+}
+
+// DelegationLink is an access token in a delegation chain
+type DelegationLink struct {
+	AccessToken string
+	ClientID    string
+	Subject     string
+	// Exchange is nil if the token was not issued by a token exchange
+	Exchange *TokenExchange
+}
+
+// tokenExchangeArgs returns the query arguments for the subject_token, actor_token
+// and issued_token_type columns
+func tokenExchangeArgs(exchange *TokenExchange) []interface{} {
+	if exchange == nil {
+		return []interface{}{nil, nil, nil}
+	}
+	return []interface{}{nullString(exchange.SubjectToken), nullString(exchange.ActorToken),
+		nullString(exchange.IssuedTokenType)}
+}
+
+// tokenExchangeColumns are the scanned token exchange columns
+type tokenExchangeColumns struct {
+	subjectToken    sql.NullString
+	actorToken      sql.NullString
+	issuedTokenType sql.NullString
+}
+
+func (c *tokenExchangeColumns) dest() []interface{} {
+	return []interface{}{&c.subjectToken, &c.actorToken, &c.issuedTokenType}
+}
+
+// data returns the scanned token exchange or nil if the columns are not set
+func (c *tokenExchangeColumns) data() *TokenExchange {
+	if !c.subjectToken.Valid && !c.actorToken.Valid && !c.issuedTokenType.Valid {
+		return nil
+	}
+	return &TokenExchange{
+		SubjectToken:    c.subjectToken.String,
+		ActorToken:      c.actorToken.String,
+		IssuedTokenType: c.issuedTokenType.String,
+	}
+}
+
+// TokenLineage walks the delegation chain of an access token. The first link is the
+// token itself, and each following link is the subject token of the previous one.
+// The chain ends at a token that was not issued by a token exchange or whose subject
+// token is not stored or revoked. It returns sql.ErrNoRows if accessToken does not
+// exist or is revoked.
+func (store *SQLStorage) TokenLineage(ctx context.Context, accessToken string) (_ []DelegationLink, err error) {
+	ctx, op := store.begin(ctx, "TokenLineage")
+	defer op.end(&err)
+	links := []DelegationLink{}
+	seen := map[string]bool{}
+	for token := accessToken; token != "" && !seen[token]; {
+		seen[token] = true
+
+		var (
+			link     DelegationLink
+			subject  sql.NullString
+			exchange tokenExchangeColumns
+		)
+		row := store.queryRow(ctx, store.authDB, store.rebind(`
+			SELECT access_token, client_id, subject, subject_token, actor_token, issued_token_type
+			FROM access_data WHERE tenant_id = ? AND access_token = ? AND revoked_at IS NULL`), store.tenantID, token)
+		dest := append([]interface{}{&link.AccessToken, &link.ClientID, &subject}, exchange.dest()...)
+		err := row.Scan(dest...)
+		if err == sql.ErrNoRows && len(links) > 0 {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		link.Subject = subject.String
+		link.Exchange = exchange.data()
+		links = append(links, link)
+
+		token = ""
+		if link.Exchange != nil {
+			token = link.Exchange.SubjectToken
+		}
+	}
+	return links, nil
+}
+
+// RevokeSubjectToken removes the access data of a token, or revokes it with
+// WithSoftDelete. If cascade is set, the tokens derived from it by token exchange,
+// and transitively the tokens derived from those, are removed in the same transaction.
+// The RemoveAccess hooks run for each removed token.
+func (store *SQLStorage) RevokeSubjectToken(ctx context.Context, accessToken string, cascade bool) (err error) {
+	ctx, op := store.begin(ctx, "RevokeSubjectToken")
+	defer op.end(&err)
+	_, err = store.revokeSubjectToken(ctx, accessToken, cascade)
+	return err
+}
+
+// revokeSubjectToken removes the access data of a token and, if cascade is set,
+// of the tokens derived from it, and returns the removed access tokens
+func (store *SQLStorage) revokeSubjectToken(ctx context.Context, accessToken string, cascade bool) ([]string, error) {
+	tx, err := store.authDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	tokens := []string{accessToken}
+	seen := map[string]bool{accessToken: true}
+	for i := 0; cascade && i < len(tokens); i++ {
+		derived, err := store.queryStrings(ctx, tx, store.rebind(
+			"SELECT access_token FROM access_data WHERE tenant_id = ? AND subject_token = ?"), store.tenantID, tokens[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range derived {
+			if !seen[token] {
+				seen[token] = true
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	for _, token := range tokens {
+		if err := store.removeAccessTx(ctx, tx, token, store.softDelete, RevokedReasonSubjectTokenRevoked); err != nil {
+			return nil, err
+		}
+	}
+	return tokens, tx.Commit()
+}
+
+// queryStrings returns the single string column of the rows of a query
+func (store *SQLStorage) queryStrings(ctx context.Context, db Querier, query string, args ...interface{}) ([]string, error) {
+	rows, err := store.query(ctx, db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
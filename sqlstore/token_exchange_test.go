@@ -0,0 +1,97 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/RangelReale/osin"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// saveExchangedToken saves an access token issued by exchanging subjectToken
+func saveExchangedToken(t *testing.T, client osin.Client, accessToken, subjectToken, actorToken string) {
+	var exchange *TokenExchange
+	if subjectToken != "" {
+		exchange = &TokenExchange{SubjectToken: subjectToken, ActorToken: actorToken,
+			IssuedTokenType: TokenTypeAccessToken}
+	}
+	accessData := &osin.AccessData{AccessToken: accessToken, ExpiresIn: 100, CreatedAt: time.Now(),
+		Client: client, UserData: &ExtendedUserData{Subject: "alice", TokenExchange: exchange}}
+	if err := testingContext.Store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenLineage(t *testing.T) {
+	ctx := context.Background()
+	store := testingContext.Store
+
+	client := &osin.DefaultClient{Id: "exchangeclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	defer store.RemoveClient(client.Id)
+
+	// frontend token -> orders service -> payments service
+	saveExchangedToken(t, client, "frontendtoken", "", "")
+	saveExchangedToken(t, client, "orderstoken", "frontendtoken", "ordersactor")
+	saveExchangedToken(t, client, "paymentstoken", "orderstoken", "paymentsactor")
+	saveExchangedToken(t, client, "paymentstoken2", "orderstoken", "")
+	saveExchangedToken(t, client, "unrelatedtoken", "", "")
+	for _, token := range []string{"frontendtoken", "orderstoken", "paymentstoken", "paymentstoken2", "unrelatedtoken"} {
+		defer store.RemoveAccess(token)
+	}
+
+	retAccessData, err := store.LoadAccess("paymentstoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &TokenExchange{SubjectToken: "orderstoken", ActorToken: "paymentsactor", IssuedTokenType: TokenTypeAccessToken}
+	if exchange := retAccessData.UserData.(*ExtendedUserData).TokenExchange; !reflect.DeepEqual(exchange, expected) {
+		t.Errorf("\"%v\": expected %v", exchange, expected)
+	}
+
+	links, err := store.TokenLineage(ctx, "paymentstoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := []string{}
+	for _, link := range links {
+		tokens = append(tokens, link.AccessToken)
+	}
+	if !reflect.DeepEqual(tokens, []string{"paymentstoken", "orderstoken", "frontendtoken"}) {
+		t.Errorf("\"%v\": expected [paymentstoken orderstoken frontendtoken]", tokens)
+	}
+	if links[1].Exchange.ActorToken != "ordersactor" || links[2].Exchange != nil || links[0].Subject != "alice" {
+		t.Errorf("Unexpected links %+v", links)
+	}
+
+	if _, err := store.TokenLineage(ctx, "missingtoken"); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+
+	// Revoking without cascade only removes the token itself
+	if err := store.RevokeSubjectToken(ctx, "paymentstoken2", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadAccess("paymentstoken2"); err != sql.ErrNoRows {
+		t.Errorf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+	if _, err := store.LoadAccess("orderstoken"); err != nil {
+		t.Error(err)
+	}
+
+	// Revoking with cascade removes the derived tokens
+	if err := store.RevokeSubjectToken(ctx, "frontendtoken", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"frontendtoken", "orderstoken", "paymentstoken"} {
+		if _, err := store.LoadAccess(token); err != sql.ErrNoRows {
+			t.Errorf("%s: \"%v\": expected %v", token, err, sql.ErrNoRows)
+		}
+	}
+	if _, err := store.LoadAccess("unrelatedtoken"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,88 @@
+package sqlstore
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// tracerName is the instrumentation scope of the spans of the storage
+const tracerName = "github.com/DarinM223/osin-sql-storage/sqlstore"
+
+// Attributes of the spans of the storage
+const (
+	attrOperation    = attribute.Key("sqlstore.operation")
+	attrErrorKind    = attribute.Key("error.type")
+	attrDBOperation  = attribute.Key("db.operation")
+	attrDBTable      = attribute.Key("db.sql.table")
+	attrRowsAffected = attribute.Key("db.rows_affected")
+)
+
+// WithTracerProvider creates a span for every storage method and a child span for
+// every SQL statement it runs. Spans carry the method, the SQL operation, the
+// table and the rows affected. Query arguments and error messages are never
+// recorded, so tokens, codes and secrets do not reach the spans; failed spans
+// are marked with the kind of the error instead.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(store *SQLStorage) {
+		store.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// endSpan records the kind of err on a span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		kind := ErrorKind(err)
+		span.SetAttributes(attrErrorKind.String(kind))
+		span.SetStatus(codes.Error, kind)
+	}
+	span.End()
+}
+
+// statementKeywords maps the operation of a statement to the keyword before its table
+var statementKeywords = map[string]string{
+	"SELECT": "FROM",
+	"DELETE": "FROM",
+	"INSERT": "INTO",
+	"UPDATE": "UPDATE",
+	"CREATE": "TABLE",
+	"ALTER":  "TABLE",
+}
+
+// statementTarget returns the operation and the table of a statement
+func statementTarget(query string) (operation, table string) {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return "", ""
+	}
+	operation = strings.ToUpper(words[0])
+	keyword := statementKeywords[operation]
+	if operation == "CREATE" && len(words) > 1 && !strings.EqualFold(words[1], "TABLE") {
+		// CREATE [UNIQUE] INDEX name ON table(columns)
+		keyword = "ON"
+	}
+	if keyword == "" {
+		return operation, ""
+	}
+
+	for i, word := range words {
+		if !strings.EqualFold(word, keyword) {
+			continue
+		}
+		rest := words[i+1:]
+		for len(rest) > 0 && (strings.EqualFold(rest[0], "IF") || strings.EqualFold(rest[0], "NOT") ||
+			strings.EqualFold(rest[0], "EXISTS")) {
+			rest = rest[1:]
+		}
+		if len(rest) > 0 {
+			table = rest[0]
+			if paren := strings.IndexByte(table, '('); paren >= 0 {
+				table = table[:paren]
+			}
+			table = strings.Trim(table, "\"`")
+		}
+		break
+	}
+	return operation, table
+}
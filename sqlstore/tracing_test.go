@@ -0,0 +1,163 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"github.com/RangelReale/osin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTracing(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	store := NewSQLStorage(db, WithTracerProvider(provider))
+
+	client := &osin.DefaultClient{Id: "traceclient", Secret: "tracesecret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "traceaccess", RefreshToken: "tracerefresh", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	exporter.Reset()
+
+	if _, err := store.LoadAccess(accessData.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans()
+	byName := map[string]tracetest.SpanStub{}
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+
+	// The statements and the nested GetClient call are children of the LoadAccess span
+	root, ok := byName["sqlstore.LoadAccess"]
+	if !ok {
+		t.Fatalf("No LoadAccess span in %v", spanNames(spans))
+	}
+	for _, name := range []string{"SELECT access_data", "sqlstore.GetClient"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Fatalf("No %s span in %v", name, spanNames(spans))
+		}
+		if span.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Errorf("%s: not in the trace of LoadAccess", name)
+		}
+	}
+	if byName["SELECT clients"].Parent.SpanID() != byName["sqlstore.GetClient"].SpanContext.SpanID() {
+		t.Errorf("SELECT clients: expected parent GetClient")
+	}
+
+	exporter.Reset()
+	if err := store.RemoveAccess(accessData.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadAccess(accessData.AccessToken); err != sql.ErrNoRows {
+		t.Fatalf("\"%v\": expected %v", err, sql.ErrNoRows)
+	}
+	spans = exporter.GetSpans()
+	for _, span := range spans {
+		attrs := map[string]string{}
+		for _, attr := range span.Attributes {
+			attrs[string(attr.Key)] = attr.Value.Emit()
+		}
+		switch span.Name {
+		case "DELETE access_data":
+			if attrs["db.operation"] != "DELETE" || attrs["db.sql.table"] != "access_data" || attrs["db.rows_affected"] != "1" {
+				t.Errorf("Unexpected attributes %v", attrs)
+			}
+		case "sqlstore.LoadAccess":
+			if attrs["error.type"] != ErrorKindNotFound {
+				t.Errorf("Unexpected attributes %v", attrs)
+			}
+		}
+	}
+	if len(spans) == 0 {
+		t.Fatal("No spans")
+	}
+}
+
+func TestTracingRedactsTokens(t *testing.T) {
+	db := openMigratedDB(t)
+	defer db.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	store := NewSQLStorage(db, WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))))
+
+	client := &osin.DefaultClient{Id: "redactclient", Secret: "secret-value", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	authorizeData := &osin.AuthorizeData{Code: "code-value", ExpiresIn: 100, CreatedAt: time.Now(), Client: client}
+	if err := store.SaveAuthorize(authorizeData); err != nil {
+		t.Fatal(err)
+	}
+	accessData := &osin.AccessData{AccessToken: "access-value", RefreshToken: "refresh-value", ExpiresIn: 100,
+		CreatedAt: time.Now(), Client: client, AuthorizeData: authorizeData}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	// A duplicate token fails with a driver error
+	if err := store.SaveAccess(accessData); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if _, err := store.LoadRefresh(accessData.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveRefresh(accessData.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, span := range exporter.GetSpans() {
+		values := []string{span.Name, span.Status.Description}
+		for _, attr := range span.Attributes {
+			values = append(values, attr.Value.Emit())
+		}
+		for _, event := range span.Events {
+			values = append(values, event.Name)
+			for _, attr := range event.Attributes {
+				values = append(values, attr.Value.Emit())
+			}
+		}
+		for _, value := range values {
+			for _, secret := range []string{"secret-value", "code-value", "access-value", "refresh-value"} {
+				if strings.Contains(value, secret) {
+					t.Errorf("%s: %q contains %s", span.Name, value, secret)
+				}
+			}
+		}
+	}
+}
+
+func TestStatementTarget(t *testing.T) {
+	for query, expected := range map[string][2]string{
+		"SELECT id FROM clients WHERE tenant_id = ?":               {"SELECT", "clients"},
+		"\n\t\tINSERT INTO access_data(tenant_id, access_token)":   {"INSERT", "access_data"},
+		"DELETE FROM \"device_codes\" WHERE tenant_id = $1":        {"DELETE", "device_codes"},
+		"UPDATE consents SET scope = ?":                            {"UPDATE", "consents"},
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version)":   {"CREATE", "schema_migrations"},
+		"CREATE INDEX idx_access_data_subject ON access_data(sub)": {"CREATE", "access_data"},
+		"PRAGMA table_info(clients)":                               {"PRAGMA", ""},
+	} {
+		operation, table := statementTarget(query)
+		if operation != expected[0] || table != expected[1] {
+			t.Errorf("%q: %s %s, expected %v", query, operation, table, expected)
+		}
+	}
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
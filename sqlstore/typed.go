@@ -0,0 +1,193 @@
+package sqlstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/RangelReale/osin"
+)
+
+// TypedSQLStorage wraps a SQLStorage so that the UserData of authorize and
+// access data is always of type T. It still satisfies osin.Storage, but
+// UserData values returned by the Load methods hold a T instead of the
+// generic maps produced by encoding/json, and saving data whose UserData is
+// not a T (or *T) fails before anything is written.
+//
+// Client user data is not affected and is returned as stored.
+type TypedSQLStorage[T any] struct {
+	*SQLStorage
+}
+
+// NewTypedSQLStorage wraps store so that authorize and access user data is typed as T
+func NewTypedSQLStorage[T any](store *SQLStorage) *TypedSQLStorage[T] {
+	return &TypedSQLStorage[T]{SQLStorage: store}
+}
+
+func (store *TypedSQLStorage[T]) Clone() osin.Storage {
+	return store
+}
+
+// checkUserData returns an error if userData is neither nil, T nor *T. The user
+// data inside an ExtendedUserData is checked instead of the wrapper.
+func checkUserData[T any](userData interface{}) error {
+	userData, _ = splitUserData(userData)
+	switch userData.(type) {
+	case nil, T, *T:
+		return nil
+	}
+	var zero T
+	return fmt.Errorf("sqlstore: user data has type %T, expected %T", userData, zero)
+}
+
+// convertUserData converts user data loaded from the database into T
+func convertUserData[T any](userData interface{}) (T, error) {
+	var data T
+
+	userData, _ = splitUserData(userData)
+	switch v := userData.(type) {
+	case nil:
+		return data, nil
+	case T:
+		return v, nil
+	case *T:
+		return *v, nil
+	}
+
+	// Re-decode the generic json value into T
+	raw, err := json.Marshal(userData)
+	if err != nil {
+		return data, err
+	}
+	err = json.Unmarshal(raw, &data)
+	return data, err
+}
+
+// typeUserData converts user data loaded from the database into a T, keeping
+// the ExtendedUserData wrapper if there is one
+func typeUserData[T any](userData interface{}) (interface{}, error) {
+	inner, ext := splitUserData(userData)
+	if inner == nil {
+		return userData, nil
+	}
+	data, err := convertUserData[T](inner)
+	if err != nil {
+		return nil, err
+	}
+	if ext.empty() {
+		return data, nil
+	}
+	ext.UserData = data
+	return ext, nil
+}
+
+// typeAccessData converts the user data of accessData and the authorize data and
+// previous access data attached to it
+func typeAccessData[T any](accessData *osin.AccessData) error {
+	if accessData == nil {
+		return nil
+	}
+	userData, err := typeUserData[T](accessData.UserData)
+	if err != nil {
+		return err
+	}
+	accessData.UserData = userData
+	if err := typeAuthorizeData[T](accessData.AuthorizeData); err != nil {
+		return err
+	}
+	return typeAccessData[T](accessData.AccessData)
+}
+
+// typeAuthorizeData converts the user data of authData
+func typeAuthorizeData[T any](authData *osin.AuthorizeData) error {
+	if authData == nil {
+		return nil
+	}
+	userData, err := typeUserData[T](authData.UserData)
+	if err != nil {
+		return err
+	}
+	authData.UserData = userData
+	return nil
+}
+
+func (store *TypedSQLStorage[T]) SaveAuthorize(authorizeData *osin.AuthorizeData) error {
+	if err := checkUserData[T](authorizeData.UserData); err != nil {
+		return err
+	}
+	return store.SQLStorage.SaveAuthorize(authorizeData)
+}
+
+func (store *TypedSQLStorage[T]) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	authData, err := store.SQLStorage.LoadAuthorize(code)
+	if err != nil {
+		return nil, err
+	}
+	if err := typeAuthorizeData[T](authData); err != nil {
+		return nil, err
+	}
+	return authData, nil
+}
+
+// LoadAuthorizeTyped loads the authorize data for code along with its decoded user data
+func (store *TypedSQLStorage[T]) LoadAuthorizeTyped(code string) (*osin.AuthorizeData, T, error) {
+	var zero T
+
+	authData, err := store.LoadAuthorize(code)
+	if err != nil {
+		return nil, zero, err
+	}
+	userData, err := convertUserData[T](authData.UserData)
+	return authData, userData, err
+}
+
+func (store *TypedSQLStorage[T]) SaveAccess(accessData *osin.AccessData) error {
+	if err := checkUserData[T](accessData.UserData); err != nil {
+		return err
+	}
+	return store.SQLStorage.SaveAccess(accessData)
+}
+
+func (store *TypedSQLStorage[T]) LoadAccess(token string) (*osin.AccessData, error) {
+	accessData, err := store.SQLStorage.LoadAccess(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := typeAccessData[T](accessData); err != nil {
+		return nil, err
+	}
+	return accessData, nil
+}
+
+// LoadAccessTyped loads the access data for token along with its decoded user data
+func (store *TypedSQLStorage[T]) LoadAccessTyped(token string) (*osin.AccessData, T, error) {
+	var zero T
+
+	accessData, err := store.LoadAccess(token)
+	if err != nil {
+		return nil, zero, err
+	}
+	userData, err := convertUserData[T](accessData.UserData)
+	return accessData, userData, err
+}
+
+func (store *TypedSQLStorage[T]) LoadRefresh(token string) (*osin.AccessData, error) {
+	accessData, err := store.SQLStorage.LoadRefresh(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := typeAccessData[T](accessData); err != nil {
+		return nil, err
+	}
+	return accessData, nil
+}
+
+// LoadRefreshTyped loads the access data for a refresh token along with its decoded user data
+func (store *TypedSQLStorage[T]) LoadRefreshTyped(token string) (*osin.AccessData, T, error) {
+	var zero T
+
+	accessData, err := store.LoadRefresh(token)
+	if err != nil {
+		return nil, zero, err
+	}
+	userData, err := convertUserData[T](accessData.UserData)
+	return accessData, userData, err
+}
@@ -0,0 +1,83 @@
+package sqlstore
+
+import (
+	"github.com/RangelReale/osin"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type typedUserData struct {
+	Username string
+	Roles    []string
+}
+
+var _ osin.Storage = (*TypedSQLStorage[typedUserData])(nil)
+
+func TestTypedStorage(t *testing.T) {
+	store := NewTypedSQLStorage[typedUserData](testingContext.Store)
+	userData := typedUserData{Username: "typeduser", Roles: []string{"admin", "user"}}
+
+	client := &osin.DefaultClient{Id: "typedclient", Secret: "secret", RedirectUri: "redirect"}
+	if err := store.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	defer store.RemoveClient(client.Id)
+
+	authData := &osin.AuthorizeData{Code: "typedcode", ExpiresIn: 100, Scope: "testscope",
+		RedirectUri: "redirect", CreatedAt: time.Now(), UserData: &userData, Client: client}
+	if err := store.SaveAuthorize(authData); err != nil {
+		t.Fatal(err)
+	}
+	defer store.RemoveAuthorize(authData.Code)
+
+	retAuthData, retUserData, err := store.LoadAuthorizeTyped(authData.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(retUserData, userData) {
+		t.Errorf("\"%v\": expected %v", retUserData, userData)
+	}
+	if _, ok := retAuthData.UserData.(typedUserData); !ok {
+		t.Errorf("Authorize user data has type %T", retAuthData.UserData)
+	}
+
+	accessData := &osin.AccessData{AccessToken: "typedaccess", RefreshToken: "typedrefresh",
+		ExpiresIn: 100, Scope: "testscope", RedirectUri: "redirect", CreatedAt: time.Now(),
+		UserData: userData, Client: client, AuthorizeData: authData}
+	if err := store.SaveAccess(accessData); err != nil {
+		t.Fatal(err)
+	}
+	defer store.RemoveAccess(accessData.AccessToken)
+
+	retAccessData, retUserData, err := store.LoadAccessTyped(accessData.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(retUserData, userData) {
+		t.Errorf("\"%v\": expected %v", retUserData, userData)
+	}
+	if _, ok := retAccessData.AuthorizeData.UserData.(typedUserData); !ok {
+		t.Errorf("Access data's authorize user data has type %T", retAccessData.AuthorizeData.UserData)
+	}
+
+	_, retUserData, err = store.LoadRefreshTyped(accessData.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(retUserData, userData) {
+		t.Errorf("\"%v\": expected %v", retUserData, userData)
+	}
+
+	// Saving user data of the wrong type should fail without writing anything
+	wrongData := *accessData
+	wrongData.AccessToken = "typedwrong"
+	wrongData.RefreshToken = "typedwrongrefresh"
+	wrongData.UserData = map[string]interface{}{"Username": "typeduser"}
+	if err := store.SaveAccess(&wrongData); err == nil {
+		t.Error("Error should be thrown")
+	}
+	if _, err := store.LoadAccess(wrongData.AccessToken); err == nil {
+		t.Error("Access data with the wrong user data type should not be saved")
+	}
+}
@@ -0,0 +1,57 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// userDataTables maps the tables with a user_data column to their primary key
+var userDataTables = map[string]string{
+	"clients":        "id",
+	"authorize_data": "code",
+	"access_data":    "access_token",
+}
+
+// QueryByUserData returns the primary keys (client ids, authorization codes or
+// access tokens) of the rows in table whose user data has value at jsonPath.
+//
+// table is one of clients, authorize_data or access_data. jsonPath supports
+// object keys and array indexes, for example $.Username or $.Roles[0]. value
+// is compared as json, so the number 1 does not match the string "1". Revoked
+// codes and tokens are not returned.
+func (store *SQLStorage) QueryByUserData(ctx context.Context, table, jsonPath string, value interface{}) (_ []string, err error) {
+	ctx, op := store.begin(ctx, "QueryByUserData")
+	defer op.end(&err)
+	key, ok := userDataTables[table]
+	if !ok {
+		return nil, fmt.Errorf("sqlstore: table %q has no user data", table)
+	}
+
+	pathArg, err := store.dialect.jsonPathArg(jsonPath)
+	if err != nil {
+		return nil, err
+	}
+
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + key + " FROM " + table + " WHERE tenant_id = ? AND " + store.dialect.jsonMatch("user_data", store.nativeJSON) + notRevoked(table)
+	rows, err := store.query(ctx, store.tableDB(table), store.rebind(query), store.tenantID, pathArg, string(valueJSON))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,51 @@
+package sqlstore
+
+import (
+	"context"
+	"github.com/RangelReale/osin"
+	"reflect"
+	"testing"
+)
+
+func TestQueryByUserData(t *testing.T) {
+	ctx := context.Background()
+	clients := []*osin.DefaultClient{
+		{Id: "userdata1", Secret: "secret", RedirectUri: "redirect",
+			UserData: map[string]interface{}{"Team": "support", "Level": 1, "Tags": []string{"a", "b"}}},
+		{Id: "userdata2", Secret: "secret", RedirectUri: "redirect",
+			UserData: map[string]interface{}{"Team": "billing", "Level": 2}},
+		{Id: "userdata3", Secret: "secret", RedirectUri: "redirect", UserData: nil},
+	}
+	for _, client := range clients {
+		if err := testingContext.Store.SetClient(client); err != nil {
+			t.Fatal(err)
+		}
+		defer testingContext.Store.RemoveClient(client.Id)
+	}
+
+	tests := []struct {
+		path     string
+		value    interface{}
+		expected []string
+	}{
+		{"$.Team", "support", []string{"userdata1"}},
+		{"$.Level", 2, []string{"userdata2"}},
+		{"$.Level", "2", []string{}},
+		{"$.Tags[1]", "b", []string{"userdata1"}},
+		{"$.Missing", "support", []string{}},
+	}
+	for _, test := range tests {
+		ids, err := testingContext.Store.QueryByUserData(ctx, "clients", test.path, test.value)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("%s = %v: \"%v\": expected %v", test.path, test.value, ids, test.expected)
+		}
+	}
+
+	if _, err := testingContext.Store.QueryByUserData(ctx, "schema_migrations", "$.Team", "support"); err == nil {
+		t.Error("Error should be thrown")
+	}
+}